@@ -4,26 +4,512 @@ Command vexp is a vendoring experiment.
 
 Usage
 
-	vexp [-v] [-u packages]
+	vexp [flags] [packages]
 
 Vexp finds all dependencies of all packages in ./...,
 and copies their files into subdirectory "vendor", such
 that the go tool will use the copied packages when run
 with GO15VENDOREXPERIMENT=1 in its environment.
 
+If package patterns are given, vexp finds the dependencies
+of only the packages they match, instead of ./... . The
+patterns must name packages inside the current directory,
+such as ./cmd/server/... . If they match no packages at
+all, vexp exits with an error, unless flag -allow-empty
+is given.
+Flag -strict goes further: if any one of the patterns
+matches no packages, such as ./cmd/... among others that
+do match, vexp reports it as an error and exits with
+status 1 without copying anything.
+
 For more details on the Go 1.5 vendor experiment, see
 https://groups.google.com/d/msg/golang-dev/74zjMON9glU/4lWCRDCRZg0J
 
 With no options, vexp only adds new packages; existing
 packages are left unchanged.
 
+Once it has copied the packages, vexp prints a summary
+line, such as "vexp: 12 added, 3 updated, 45 unchanged",
+counting the packages copied for the first time, those
+copied again to replace an older copy, and those already
+vendored and left alone.
+
+Like the go tool, vexp ignores files and directories
+whose names start with "." or "_", and directories named
+testdata, both when matching package patterns and when
+copying dependencies. Flag -copy-underscore copies those
+starting with "_" from dependencies anyway, for packages
+that keep needed files there. It doesn't change which
+packages the patterns match.
+
+Files a dependency embeds with //go:embed directives are
+copied whatever the rules here and the flags below would
+say, even in testdata, so the vendored copy still builds.
+Vexp warns about any pattern that matches no files.
+
+Symbolic links in a dependency's directory are followed:
+vexp copies the file or directory they point to, not the
+link itself, skipping any link that leads back into a
+directory it is already copying.
+
+A package with an import comment, such as
+package foo // import "example.com/foo", is vendored at
+the path it names, where the go tool expects it. Importing
+it by any other path is an error, as it is for the go tool.
+
+If two files to be vendored have paths that differ only
+in case, vexp reports an error and leaves the package being
+copied as it was, since on a case-insensitive file system,
+such as the default on macOS, one would overwrite the other.
+
+If a dependency's #cgo flags name include directories
+(with -I) outside the package's own directory but inside
+its repository, vexp copies those directories too, to the
+same place relative to the vendored package. It warns
+about include directories outside the repository, which
+it can't copy.
+
+Vexp copies the .swig and .swigcxx files of packages that
+use SWIG, but not the Go and C code swig generates from
+them, which the go tool makes afresh at build time. It
+warns about each such package, since building it needs
+swig installed.
+
+If the same import path resolves to different source
+directories for different packages, for instance because
+one root package has its own vendored copy and another
+uses the one in $GOPATH, vexp prints a warning listing
+the directories.
+
+Vexp warns about any dependency with no Go files to
+build, such as one holding only tests, or only files for
+other targets, since copying it is pointless, and usually
+means the import resolved to the wrong place.
+
+Likewise, if a dependency to be copied has the import path
+of one of the packages in the current directory, such as
+a copy of it in another dependency's vendor tree, vexp
+warns that it would shadow our own package, listing both
+directories.
+
+Flag -warn-multimajor warns about each package that the
+dependencies include at more than one major version, such
+as example.com/foo and example.com/foo/v2, going by a /vN
+element in the import path. That doubles the code vendored,
+and often means a dependency needs upgrading.
+
 Flag -u updates already-vendored dependencies. It takes
-a colon-separated list of package patterns. If any
+a list of package patterns, separated by colons or
+commas (or, on Windows, semicolons). If any
 dependency matches one of these patterns, it will be
 copied from $GOPATH into the vendor directory, even if
-already present.
+already present. The pattern all matches every package,
+so -u=all updates every dependency. Vexp warns about any
+pattern that matches no dependency, which may be a typo.
+
+A pattern given to -u with a leading "!" leaves the
+packages it matches alone, even if another pattern
+matches them too, whatever the order. For example,
+-u='github.com/foo/...:!github.com/foo/legacy' updates
+every package under github.com/foo but legacy. (Quote
+the list, as a shell may give "!" a meaning of its own.)
+
+Flag -since updates, as if by -u, each already-vendored
+dependency with a source file modified after the given
+time, written in RFC 3339 format, such as
+2015-06-01T12:00:00Z. With -since=last, the time is that
+of the newest file in the vendor tree (under -gopath-layout,
+its src directory). Since copies keep
+their sources' modification times, that catches sources
+changed after they were last vendored.
+
+Flag -exclude takes a list of package patterns, like
+-u. Vexp neither vendors nor looks for dependencies of
+any package matching one of them, even if it is missing
+from $GOPATH. Use it for packages that are provided by
+the target environment.
+
+Flag -add takes a list of import paths, separated like
+those of -u, and vendors those packages and their dependencies as if a
+root package imported them. Use it to vendor a package
+before any code imports it, or a command, such as a code
+generator run by go generate, that nothing can import.
+
+Flag -allow takes a list of import path prefixes, such as
+github.com/myorg:golang.org/x, separated like the patterns
+of -u, and makes it an error to vendor any package outside
+them. The error names the package and the root package
+that needs it. Standard library packages are never
+vendored, so they need not be allowed.
+
+Flag -require-vcs makes it an error to vendor a package
+that is not in a version control repository, one marked
+by a .git, .hg, .bzr, or .svn directory in or above the
+package's directory, within its $GOPATH/src. Such code
+was likely copied into $GOPATH by hand, and can't be
+traced to its origin. Packages in the module cache, found
+with -modcache, have versions of their own and are exempt.
+
+Packages in GOROOT whose import paths have no dot are
+taken to be in the standard library, and never vendored.
+With a custom toolchain that keeps other packages there,
+flag -vendor-goroot takes a list of import path prefixes,
+separated like the patterns of -u, of GOROOT packages to
+vendor like any other dependency.
+
+Flag -map loads packages from other directories than
+$GOPATH, such as a patched fork. It takes a comma-separated
+list of prefix=dir entries, such as
+github.com/user/repo=/tmp/repo-fork, and loads each package
+under an import path prefix from the same place under the
+directory, along with its imports, using the longest prefix
+that matches. As with $GOPATH, packages already vendored
+are left alone unless -u matches them.
+
+Flag -snapshot names a directory holding the source of
+dependencies under their import paths, like the src
+directory of a GOPATH entry, such as a frozen copy kept
+for hermetic builds. Each package outside the current
+directory is loaded from the snapshot, if there, before
+looking in $GOPATH, so what is vendored doesn't depend on
+the state of $GOPATH. Flag -map takes precedence.
+
+Flag -from names a JSON file, such as one written by
+another tool, mapping import paths to source directories,
+and copies exactly those packages instead of resolving
+imports, for example:
+
+	{"github.com/user/pkg": "/src/pkg"}
+
+Relative directories are relative to the file. Each must
+exist and hold Go files. Package patterns, and flags that
+control resolving, such as -u, have no effect.
+
+Flag -o names the directory, relative to the current
+directory, to copy packages into. It defaults to "vendor".
+Existing packages are looked for in directories with the
+same name. If something other than a directory has that
+name, vexp stops before copying anything and says so.
+
+Flag -clean removes everything in the output directory
+before resolving and copying, so that no stale package
+survives, whether or not anything still imports it. With
+-n, it prints what it would remove, and resolves as if it
+had. As a safety check, it refuses to remove a directory
+holding a .git, .hg, .bzr, or .svn directory, which vexp
+never copies, or a Go file that imports a package of the
+current directory by its import path, as vendored code
+never does, such as when -o names a directory of the
+current package's own code. The output directory is
+always inside the current directory.
+
+Flag -gopath-layout copies packages into the src
+subdirectory of the output directory instead, as in a
+GOPATH entry, so that, with -o=gopath, the output can
+be added to $GOPATH to compare against the vendor tree.
+Packages are still resolved as without it.
+
+Flag -check-gitignore warns, after copying, if git would
+ignore the output directory, going by the .gitignore files
+of the git repository holding the current directory, and
+its info/exclude file, since the vendored code would then
+be left out of commits. It reads the files itself, rather
+than running git.
+
+Flag -manifest writes a file vexp.json into the output
+directory, listing the import path and source directory
+of each vendored package, sorted by import path.
+If the source directory is in a git repository, the
+manifest also records the commit checked out there.
+It also records the SHA-256 checksum of every file in
+each package's vendored copy.
+
+Flag -verify-manifest checks the vendor tree against the
+checksums in its manifest instead of copying anything.
+It doesn't need the packages' sources. It reports any
+file that is missing, has changed, or is not listed,
+and exits with status 1 if it found any.
+
+Flag -verify checks that the vendor tree is up to date
+instead of copying anything. It compares every vendored
+file with its source, reports any that are missing, differ,
+or should not be there, and exits with status 1 if it
+found any.
+
+Flag -json prints the dependency graph as JSON instead of
+copying anything: a list of the packages to vendor, sorted
+by import path, giving each one's source directory, its
+imports, and whether it is already vendored.
+
+Flag -list prints the import paths of the packages to
+vendor, one per line, instead of copying anything, for
+other tools to read. Flag -list-format, which implies
+-list, prints each package with the given template
+instead, as for go list -f; for example,
+-list-format='{{.ImportPath}} {{.Dir}}'.
+
+Flag -report prints a table of the packages to vendor
+and those already vendored, instead of copying anything,
+for reviewing their licenses. It gives each one's version,
+as recorded by -manifest, and its license: the license
+files found, as by -licenses, each with its SPDX identifier,
+such as MIT or Apache-2.0, if vexp recognizes the text,
+or else "unknown". For packages already vendored, it looks
+for license files only inside the vendor tree.
+
+Flag -why prints the shortest chain of imports that
+leads from a root package to the named package, instead
+of copying anything, or says that it is not a dependency.
+The chain starts with the root and lists one import per
+line. Flag -why-all is like -why, but prints every such
+chain.
+
+Flag -check-dupes looks through the existing vendor tree,
+instead of copying anything, for packages vendored more
+than once, at different depths, such as vendor/x and
+vendor/a/vendor/x, which leave it unclear which copy a
+build uses. It lists each one with the directories that
+hold it, and exits with status 1 if it found any. Flag
+-flatten avoids making such trees.
+
+Flag -dot prints the dependency graph in Graphviz dot
+format instead of copying anything. Root packages are
+drawn as boxes. Standard library packages are left out.
+
+Flag -notest leaves _test.go files out of the vendor tree.
+The imports of those files are still vendored.
+
+Flag -strip gives finer control. It takes a list of
+categories of files to leave out, separated like the
+patterns of -u: examples, for example*_test.go files;
+tests, for all _test.go files; and docs, for doc.go
+files. So -strip=examples keeps other tests.
+
+Flag -testdeps controls whose test imports are vendored.
+With -testdeps=all, the default, vexp vendors the test
+imports of every package it finds. With -testdeps=roots,
+it vendors only the test imports of packages in ./...,
+not those of their dependencies.
+
+By default, vexp follows the imports of every Go file,
+whatever target system it is for. Flags -goos and -goarch
+restrict it to the files used when building for the given
+operating system and architecture, as selected by build
+constraints and file names. If only one is given, the
+other defaults to that of the host. Source files that
+target would not build, including those left out by
+custom build tags, are left out of the vendor tree too.
+
+Flag -platforms takes a comma-separated list of os/arch
+pairs, such as linux/amd64,darwin/arm64. Vexp resolves
+dependencies once for each, vendors the union of the
+packages found, and leaves out any source files that
+none of the listed targets would build. The manifest, if
+any, lists the platforms considered.
+
+Flag -tags takes a comma- or space-separated list of build
+tags, such as purego,netgo, to consider satisfied, as with
+go build -tags, when -goos, -goarch, or -platforms choose
+files by target. Files those tags enable, and their imports,
+are then vendored too. Without those flags, every file is
+followed anyway.
+
+Flag -j sets how many packages to copy at once. It
+defaults to the number of CPUs.
+
+Flag -link makes hard links in the vendor tree instead of
+copying files, where the file system allows it, and falls
+back to copying where it doesn't. Beware that editing a
+linked file in place changes it in both trees.
+
+Flag -reflink clones files copy-on-write instead of copying
+them, where the file system allows it, as do Btrfs and XFS
+on Linux and APFS on macOS, and falls back to copying where
+it doesn't. A clone takes no time or space to make, however
+large the file, but unlike a hard link, it is a separate
+file: editing either copy leaves the other alone.
+
+Flag -fsync flushes each file copied, and then the
+directories holding them, to stable storage before going
+on, so that a crash or power loss can't leave behind empty
+or partly written files that later builds take for good
+copies. It makes copying slower, so it is off by default.
+
+Flag -licenses copies license files (LICENSE, LICENCE,
+COPYING, and NOTICE, with any extension) into each
+vendored package that has none of its own, from the
+nearest parent directory that has them, up to the root of
+the package's repository. It also prints a warning for
+each package with no license file at all, followed by a
+count of such packages.
+
+Flag -copy-gomod copies the go.mod file of each vendored
+package's module, and the go.sum beside it, if any, into
+the package's directory, from the nearest parent directory
+that has one, up to the root of the package's repository,
+for tools that read module metadata. A package with a
+go.mod of its own keeps it.
+
+Flag -gofilesonly copies only the files the go tool
+builds (.go, .c, .cc, .cpp, .cxx, .m, .h, .hh, .hpp,
+.hxx, .s, .S, .syso, .swig, and .swigcxx) and license
+files, leaving out documentation, images, and other data.
+
+Flag -minimal copies only the files go/build lists for
+each package: its Go, cgo, assembly, and other source
+files, and its tests unless -notest is given, along with
+license files and any cgo include directories. Stray
+files, such as editor backups, documentation, and data,
+are left out, as are subdirectories holding no vendored
+package.
+
+Flag -prune leaves out the subpackages of a dependency
+that are not themselves dependencies, such as commands
+under its cmd directory, along with their files. Other
+subdirectories, like templates or testdata, are still
+copied, as is any dependency found below a left-out
+package.
+
+Flag -max-file-size leaves out any file larger than the
+given number of bytes, such as a bundled test corpus,
+except .go files, which the package needs to compile.
+With -v, vexp names each file it skips.
+
+Flag -normalize-eol rewrites CRLF line endings to LF in
+the Go, C, and assembly source files it copies, such as
+those checked out on Windows. Other files, like .syso
+objects, are copied byte for byte.
+
+Flag -flatten leaves out the vendor directories of the
+packages being copied. Vexp resolves their dependencies
+from $GOPATH and vendors them at the top level anyway,
+so the nested copies are redundant.
+
+Flag -nested does the opposite. Vexp resolves each
+dependency's imports as the go tool would, from the
+dependency's own vendor directories first, and vendors
+at the top level only the packages they don't provide.
+Use it to let two dependencies keep different versions
+of a package they share. It can't be combined with
+-flatten.
+
+If the current directory has a file named .vexpignore,
+vexp reads glob patterns from it, one per line, and skips
+files that match any of them when copying. Patterns are
+matched, as by filepath.Match, against each file's path
+relative to its package directory. A pattern ending in /
+matches a whole subdirectory. Blank lines and lines
+starting with # are ignored.
+
+Patterns after a line naming an import path pattern in
+brackets, such as [github.com/user/repo/...], apply only
+to the packages it matches, up to the next such line, to
+leave out, say, one huge fixture in one library:
+
+	[github.com/user/repo]
+	fixtures/huge.json
+
+Flag -v prints each package as it is copied, labeled
+"add" if it is new to the vendor tree, "update" if it
+replaces a copy already there (see -u), or "unchanged"
+if the copy there is up to date and left alone.
+Giving -v twice, or -v=2, also prints each vendor
+directory searched while resolving imports, and -v=3
+prints each file copied as well.
+
+Flag -progress prints a line "copied N/M packages" to
+standard error as packages finish copying, less noisy than
+-v for long runs. On a terminal, it rewrites the one line
+in place, unless -v or -n is given too; otherwise, it prints
+a new line each second, and one when all are done.
+
+Flag -n prints the packages that would be copied, as -v
+does, without copying them.
+
+If packages import each other in a cycle, vexp reports
+each cycle once, as a ring of import paths such as
+"a -> b -> c -> a", rather than an error for each package
+in it, and copies nothing.
+
+Flag -ignore-vendor-errors passes over any package in the
+vendor tree that fails to load, such as a broken or half
+copied one, and loads the package it was copied from in
+$GOPATH instead, so that it is copied over the broken
+copy. Vexp prints a warning, with the error, for each.
+
+Flag -keep-going copies the packages that loaded even if
+others failed to, instead of stopping before copying
+anything. Vexp still prints the errors, and still exits
+with status 1 once it is done.
+
+While it may change the vendor tree, vexp holds a lock
+file, .vexp.lock, in the output directory, so that two
+runs at once, say by CI jobs sharing a checkout, don't
+corrupt it. If another run holds the lock, vexp exits
+with status 1 at once, or, with flag -lock-timeout, waits
+up to the given duration for it. A lock file left behind
+by a run that was killed must be removed by hand.
+
+Flag -timeout limits how long vexp may run, as a duration
+such as 5m. If time runs out, vexp stops between files,
+leaving any package it had not finished copying as it
+was, and exits with status 1.
+
+Flag -errors-json makes vexp, if any packages fail to
+load, also print the errors to standard output as a JSON
+array, for other programs to read. Each element gives the
+package's ImportPath, the ImportStack of packages leading
+to it from a root, the Pos of the error in a source file,
+if known, the error text Err, and whether it IsImportCycle.
+
+Flag -cgo-report lists, after copying, the vendored
+packages that import "C", one per line, so you know
+where cgo is involved.
+
+Flag -exec runs a shell command once for each package
+copied, after all the copying is done, to fix up the
+vendored copy. The command runs in the current directory,
+with the package's import path in environment variable
+VEXP_IMPORTPATH and its vendored directory in VEXP_DIR.
+Vexp stops, with status 1, at the first command that
+fails. With -n, no commands are run.
+
+Flag -q silences warnings and the summary, leaving only
+errors. It can't be combined with -v.
+
+Flag -log-format=json prints errors, warnings, the summary,
+and what -v reports as JSON objects, one per line, for a
+log pipeline to read. Each has fields Event, such as
+"root", "add", "copy", "warning", or "error"; Level, one
+of "error", "warning", "info", or "debug"; Message, the
+line as it would be printed otherwise; and, where they
+apply, ImportPath, Dir, the source directory or file,
+and Dest, where it is copied to. Reports asked for by
+other flags, such as -json or -stats, are unchanged.
+
+Flag -stats prints the number and total size of the
+files copied for each package, largest first, and a
+grand total. With -n, it counts what would be copied.
+
+Flag -modcache looks for packages that are missing from
+$GOPATH in the module cache, at the versions required by
+the go.mod file in the current directory or its nearest
+parent, honoring its replace directives. With -modcache,
+the current directory need not be in $GOPATH: its packages
+get their import paths from the module path in go.mod.
+The manifest records the version of each package taken
+from the module cache in place of a commit.
+
+Copied files and directories keep the permissions and
+modification times of their sources. Files that are
+unchanged since the last run are left as they were, and
+so is a vendored package that is up to date as a whole.
 
 For more about specifying packages, see 'go help packages'.
 
+To vendor packages from another program, without running
+vexp, use package github.com/kr/vexp/vendoring, which
+does the work for vexp.
+
 */
 package main
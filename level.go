@@ -0,0 +1,38 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// A levelFlag is a flag that can be given as -v=n to set
+// its level, or repeated as -v -v to count up to it.
+type levelFlag int
+
+func (l *levelFlag) String() string   { return strconv.Itoa(int(*l)) }
+func (l *levelFlag) IsBoolFlag() bool { return true }
+
+func (l *levelFlag) Set(s string) error {
+	if s == "true" {
+		*l++
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid level %q", s)
+	}
+	*l = levelFlag(n)
+	return nil
+}
+
+// levelVar defines a levelFlag with the given name and usage.
+func levelVar(name, usage string) *levelFlag {
+	l := new(levelFlag)
+	flag.Var(l, name, usage)
+	return l
+}
@@ -6,746 +6,469 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"go/build"
-	"go/token"
-	"io"
-	"log"
 	"os"
-	pathpkg "path"
 	"path/filepath"
-	"regexp"
-	"sort"
+	"runtime"
 	"strings"
-	"unicode"
-	"unicode/utf8"
+	"time"
+
+	"github.com/kr/vexp/vendoring"
 )
 
 var (
-	update  = flag.String("u", "", "update `packages` (colon-separated list of patterns)")
-	verbose = flag.Bool("v", false, "verbose")
+	update     = flag.String("u", "", "update `packages` (list of patterns, or all; prefix a pattern with ! to leave its packages alone)")
+	since      = flag.String("since", "", "also update packages changed after `time` (RFC 3339, or last)")
+	verbose    = levelVar("v", "verbose; repeat or give a `level` (up to 3) for more")
+	quiet      = flag.Bool("q", false, "print only errors")
+	output     = flag.String("o", "vendor", "copy packages into `dir`")
+	gopathOut  = flag.Bool("gopath-layout", false, "copy packages into the src subdirectory of the -o dir, as in a GOPATH entry")
+	manif      = flag.Bool("manifest", false, "write a manifest of vendored packages to "+vendoring.ManifestName)
+	verify     = flag.Bool("verify", false, "check that the vendor tree is up to date, without copying")
+	verifyMan  = flag.Bool("verify-manifest", false, "check the vendor tree against the checksums in its manifest, without copying")
+	notest     = flag.Bool("notest", false, "don't copy _test.go files")
+	strip      = flag.String("strip", "", "don't copy files in `categories` (list of examples, tests, docs)")
+	testDeps   = flag.String("testdeps", "all", "vendor test imports of `which` packages: all or roots")
+	goos       = flag.String("goos", "", "follow only imports used on target operating system `os`")
+	goarch     = flag.String("goarch", "", "follow only imports used on target architecture `arch`")
+	tags       = flag.String("tags", "", "consider build tags in `list` satisfied, as with go build -tags, when choosing files for -goos, -goarch, or -platforms")
+	plats      = flag.String("platforms", "", "copy only files used on the targets in `list` (comma-separated os/arch pairs)")
+	jobs       = flag.Int("j", runtime.GOMAXPROCS(0), "copy up to `n` packages in parallel")
+	cowClone   = flag.Bool("reflink", false, "clone files copy-on-write instead of copying them, where the file system can")
+	progress   = flag.Bool("progress", false, "print a line to stderr counting the packages copied so far")
+	fsync      = flag.Bool("fsync", false, "flush each file copied, and its directory, to disk before going on, for durability across crashes")
+	hardlink   = flag.Bool("link", false, "hard link files instead of copying them, where possible")
+	exclude    = flag.String("exclude", "", "don't vendor `packages` (list of patterns)")
+	errorsJSON = flag.Bool("errors-json", false, "if packages fail to load, print the errors as JSON")
+	jsonOut    = flag.Bool("json", false, "print the dependency graph as JSON, without copying")
+	dotOut     = flag.Bool("dot", false, "print the dependency graph in Graphviz dot format, without copying")
+	list       = flag.Bool("list", false, "print the import paths of the packages that would be copied, one per line, without copying")
+	listFormat = flag.String("list-format", "", "with -list, print each package using the go list -f style `template` instead")
+	report     = flag.Bool("report", false, "print each package's version and license, without copying")
+	licenses   = flag.Bool("licenses", false, "copy license files from parent directories")
+	goOnly     = flag.Bool("gofilesonly", false, "copy only source files the go tool builds, and licenses")
+	underscore = flag.Bool("copy-underscore", false, "copy files and directories in dependencies whose names start with _")
+	flatten    = flag.Bool("flatten", false, "don't copy dependencies' own vendor directories")
+	nested     = flag.Bool("nested", false, "resolve dependencies' imports from their own vendor directories first")
+	clean      = flag.Bool("clean", false, "remove everything in the output directory first, so no stale package survives")
+	dryRun     = flag.Bool("n", false, "print the packages that would be copied, without copying")
+	stats      = flag.Bool("stats", false, "print the number and size of files copied for each package")
+	modcache   = flag.Bool("modcache", false, "look in the module cache for packages missing from $GOPATH")
+	strict     = flag.Bool("strict", false, "fail if any one of the package patterns matches no packages")
+	allowEmpty = flag.Bool("allow-empty", false, "succeed even if the package patterns match no packages")
+	ignoreVErr = flag.Bool("ignore-vendor-errors", false, "load packages whose vendored copies have errors from $GOPATH instead, and copy them again")
+	keepGoing  = flag.Bool("keep-going", false, "copy the packages that loaded, despite errors loading others")
+	whyAll     = flag.String("why-all", "", "print every import chain from a root to `package`, without copying")
+	why        = flag.String("why", "", "print the shortest import chain from a root to `package`, without copying")
+	allow      = flag.String("allow", "", "vendor only packages under the import path prefixes in `list`")
+	goroot     = flag.String("vendor-goroot", "", "vendor packages in GOROOT under the import path prefixes in `list`, rather than treat them as standard")
+	from       = flag.String("from", "", "copy the packages listed in JSON `file`, mapping import paths to directories, without resolving imports")
+	mapList    = flag.String("map", "", "load packages under each import path prefix from a directory, given as comma-separated prefix=dir `list`")
+	snapshot   = flag.String("snapshot", "", "load dependencies from `dir`, a tree of sources by import path, before looking in $GOPATH")
+	add        = flag.String("add", "", "vendor `packages` (list of import paths) even if nothing imports them")
+	cgoReport  = flag.Bool("cgo-report", false, "list the vendored packages that use cgo")
+	checkDupes = flag.Bool("check-dupes", false, "list packages vendored more than once at different depths, without copying")
+	execCmd    = flag.String("exec", "", "run shell `command` for each package copied, with $VEXP_IMPORTPATH and $VEXP_DIR set")
+	maxSize    = flag.Int64("max-file-size", 0, "don't copy files other than .go files larger than `n` bytes (0 means no limit)")
+	copyGoMod  = flag.Bool("copy-gomod", false, "copy the go.mod and go.sum of each dependency's module into its vendored directory")
+	requireVCS = flag.Bool("require-vcs", false, "fail if a dependency is not in a version control repository")
+	gitIgnore  = flag.Bool("check-gitignore", false, "warn if the vendor directory is ignored by git, so it would not be committed")
+	multiMajor = flag.Bool("warn-multimajor", false, "warn about packages vendored at more than one major version, like foo and foo/v2")
+	prune      = flag.Bool("prune", false, "leave out subpackages of dependencies that aren't dependencies themselves")
+	minimal    = flag.Bool("minimal", false, "copy only the files go/build lists for each package, and licenses")
+	normEOL    = flag.Bool("normalize-eol", false, "rewrite CRLF line endings in source files to LF")
+	lockWait   = flag.Duration("lock-timeout", 0, "wait up to `d` for another run to release the vendor tree, instead of failing at once")
+	logFormat  = flag.String("log-format", "text", "print errors, warnings, and progress reports as `format`: text, or json for one JSON object per line")
+	timeout    = flag.Duration("timeout", 0, "give up if the run takes longer than `d` (0 means no limit)")
 )
 
 func usage() {
-	fmt.Fprintln(os.Stderr, "Usage: vexp [-v] [-u packages]")
+	fmt.Fprintln(os.Stderr, "Usage: vexp [flags] [packages]")
 	flag.PrintDefaults()
-	os.Exit(2)
+	exit(2)
 }
 
-var (
-	cwd, _       = os.Getwd()
-	gobin        = os.Getenv("GOBIN")
-	buildContext = defaultBuildContext()
-	// list of import paths not to search for in vendor directories
-	skipVendor []func(string) bool
-)
-
 func main() {
 	flag.Usage = usage
 	flag.Parse()
-	skipVendor = flagUPats(*update)
-	roots := packages(matchPackagesInFS("./..."))
-	if len(roots) == 0 {
-		fmt.Fprintln(os.Stderr, "warning: ./... matched no packages")
-	}
-	deps := dependencies(roots)
-	ok := true
-	for _, pkg := range append(roots, deps...) {
-		if pkg.Error != nil {
-			fmt.Fprintln(os.Stderr, pkg.Error)
-			ok = false
-		}
-		if pkg.Standard {
-			fmt.Fprintf(os.Stderr, "package %s is in the standard library\n", pkg.ImportPath)
-			ok = false
-		}
+	if *quiet && *verbose > 0 {
+		fmt.Fprintln(os.Stderr, "flags -q and -v can't be used together")
+		exit(2)
 	}
-	if !ok {
-		fmt.Fprintln(os.Stderr, "error(s) loading dependencies")
-		os.Exit(1)
+	if *flatten && *nested {
+		fmt.Fprintln(os.Stderr, "flags -flatten and -nested can't be used together")
+		exit(2)
 	}
-
-	var seen []string
-	for _, pkg := range deps {
-		if isSeen(pkg, seen) {
-			continue
-		}
-		seen = append(seen, pkg.ImportPath)
-		copyDep(pkg)
+	if *strict && *allowEmpty {
+		fmt.Fprintln(os.Stderr, "flags -strict and -allow-empty can't be used together")
+		exit(2)
 	}
-}
-
-func flagUPats(u string) (a []func(string) bool) {
-	for _, pat := range splitList(u) {
-		a = append(a, matchPattern(pat))
+	if *testDeps != "all" && *testDeps != "roots" {
+		fmt.Fprintf(os.Stderr, "invalid -testdeps %q: must be all or roots\n", *testDeps)
+		exit(2)
 	}
-	return
-}
-
-// dependencies returns the list of dependencies
-// of the given packages,
-// excluding any from cwd or the standard library.
-func dependencies(packages []*Package) (deps []*Package) {
-	for _, p := range packages {
-		if *verbose {
-			fmt.Println("root", p.ImportPath)
-		}
-		for _, d := range p.deps {
-			if inCWD(d.Dir) {
-				continue
-			}
-			deps = append(deps, d)
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	c := vendoring.New(cwd)
+	if *quiet {
+		c.Verbosity = -1
+	}
+	if *verbose > 0 {
+		c.Verbosity = int(*verbose)
+	}
+	c.LogFormat = *logFormat
+	c.Update = splitList(*update)
+	c.Exclude = splitList(*exclude)
+	c.Add = splitList(*add)
+	c.Allow = splitList(*allow)
+	c.Goroot = splitList(*goroot)
+	c.Map, err = vendoring.ParseMap(*mapList)
+	if err != nil {
+		c.PrintError("", err)
+		exit(2)
+	}
+	c.Snapshot = *snapshot
+	if *verify {
+		// Resolve every dependency outside the vendor tree,
+		// so there is something to compare against,
+		// whatever -u says, even if it negates some.
+		c.Update = []string{"..."}
+	}
+	if *clean && *dryRun {
+		// Nothing is removed, so resolve as if it were.
+		c.Update = []string{"..."}
+	}
+	c.OutDir = *output
+	c.GopathLayout = *gopathOut
+	switch *since {
+	case "", "last":
+		// For last, see below, once there is a Resolver.
+	default:
+		c.Since, err = time.Parse(time.RFC3339, *since)
+		if err != nil {
+			c.PrintError("", fmt.Errorf("invalid -since %q: want an RFC 3339 time or last", *since))
+			exit(2)
 		}
 	}
-	sort.Sort(byImportPath(deps))
-	return deps
-}
-
-func isSeen(pkg *Package, seen []string) bool {
-	for _, prefix := range seen {
-		if hasPathPrefix(pkg.ImportPath, prefix) {
-			return true
+	c.NoTest = *notest
+	c.Strip = splitList(*strip)
+	c.TestDeps = *testDeps
+	c.Context.BuildTags = strings.FieldsFunc(*tags, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+	if *goos != "" || *goarch != "" {
+		if *plats != "" {
+			c.PrintError("", errors.New("flag -platforms can't be used with -goos or -goarch"))
+			exit(2)
 		}
+		vendoring.SetPlatform(&c.Context, *goos, *goarch)
 	}
-	return false
-}
-
-// An importStack is a stack of import paths.
-type importStack []string
-
-func (s *importStack) push(p string) {
-	*s = append(*s, p)
-}
-
-func (s *importStack) pop() {
-	*s = (*s)[0 : len(*s)-1]
-}
-
-func (s *importStack) copy() []string {
-	return append([]string{}, *s...)
-}
-
-// shorterThan returns true if sp is shorter than t.
-// We use this to record the shortest import sequence
-// that leads to a particular package.
-func (sp *importStack) shorterThan(t []string) bool {
-	s := *sp
-	if len(s) != len(t) {
-		return len(s) < len(t)
+	c.Platforms, err = vendoring.ParsePlatforms(*plats)
+	if err != nil {
+		c.PrintError("", err)
+		exit(2)
+	}
+	c.Jobs = *jobs
+	c.Link = *hardlink
+	c.Reflink = *cowClone
+	c.Fsync = *fsync
+	c.Progress = *progress
+	c.Licenses = *licenses
+	c.GoFilesOnly = *goOnly
+	c.Flatten = *flatten
+	c.Underscore = *underscore
+	c.Nested = *nested
+	c.DryRun = *dryRun
+	c.Stats = *stats
+	c.ModCache = *modcache
+	c.NormalizeEOL = *normEOL
+	c.Minimal = *minimal
+	c.Prune = *prune
+	c.RequireVCS = *requireVCS
+	c.CopyGoMod = *copyGoMod
+	c.IgnoreVendorErrors = *ignoreVErr
+	c.MaxFileSize = *maxSize
+	c.Exec = *execCmd
+	c.Ignores, c.PackageIgnores, err = vendoring.ReadIgnore(filepath.Join(cwd, vendoring.IgnoreName))
+	if err != nil {
+		c.PrintError("", err)
+		exit(1)
 	}
-	// If they are the same length, settle ties using string ordering.
-	for i := range s {
-		if s[i] != t[i] {
-			return s[i] < t[i]
-		}
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
 	}
-	return false // they are equal
-}
-
-// A Package describes a single package found in a directory.
-type Package struct {
-	*build.Package
-	Standard   bool          // is this package part of the standard Go library?
-	Error      *PackageError // error loading this package (not dependencies)
-	loadedDeps bool
-	deps       []*Package
-}
-
-func (p *Package) copyBuild(pp *build.Package) {
-	p.Package = pp
-	p.Standard = p.Goroot && p.ImportPath != "" && !strings.Contains(p.ImportPath, ".")
-}
-
-// packages returns the packages named by the
-// command line arguments 'args'.  If there is an error
-// loading the package (for example, if the directory does not exist),
-// then packages returns a *Package for that argument with p.Error != nil.
-func packages(args []string) []*Package {
-	var pkgs []*Package
-	var stk importStack
-	var set = make(map[string]bool)
-
-	for _, arg := range args {
-		if !set[arg] {
-			pkgs = append(pkgs, loadPackage(arg, &stk))
-			set[arg] = true
-		}
-	}
-
-	return pkgs
-}
-
-// loadPackage is like loadImport but is used for command-line arguments,
-// not for paths found in import statements.  In addition to ordinary import paths,
-// loadPackage accepts pseudo-paths beginning with cmd/ to denote commands
-// in the Go command directory, as well as paths to those directories.
-func loadPackage(arg string, stk *importStack) *Package {
-	// If it is a local import path but names a standard package,
-	// we treat it as if the user specified the standard package.
-	// This lets you run go test ./ioutil in package io and be
-	// referring to io/ioutil rather than a hypothetical import of
-	// "./ioutil".
-	if build.IsLocalImport(arg) {
-		bp, _ := buildContext.ImportDir(filepath.Join(cwd, arg), build.FindOnly)
-		if bp.ImportPath != "" && bp.ImportPath != "." {
-			arg = bp.ImportPath
-		}
-	}
-	return loadImport(arg, cwd, nil, stk, nil)
-}
-
-// packageCache is a lookup cache for loadPackage,
-// so that if we look up a package multiple times
-// we return the same pointer each time.
-var packageCache = map[string]*Package{}
-
-// loadImport scans the directory named by path, which must be a non-local import path.
-// It returns a *Package describing the package found in that directory.
-func loadImport(path, srcDir string, parent *Package, stk *importStack, importPos []token.Position) *Package {
-	stk.push(path)
-	defer stk.pop()
-
-	// Determine canonical identifier for this package.
-	// For a local import the identifier is the pseudo-import path
-	// we create from the full directory to the package.
-	// Otherwise it is the usual import path.
-	// For vendored imports, it is the expanded form.
-	importPath := path
-	var vendorSearch []string
-	path, vendorSearch = vendoredImportPath(parent, path)
-	importPath = path
-
-	if p := packageCache[importPath]; p != nil {
-		return reusePackage(p, stk)
+	r, err := vendoring.NewResolver(c)
+	if err != nil {
+		c.PrintError("", err)
+		exit(2)
 	}
-
-	p := new(Package)
-	packageCache[importPath] = p
-
-	// Load package.
-	// Import always returns bp != nil, even if an error occurs,
-	// in order to return partial information.
-	//
-	// TODO: After Go 1, decide when to pass build.AllowBinary here.
-	// See issue 3268 for mistakes to avoid.
-	bp, err := buildContext.Import(path, srcDir, build.ImportComment)
-
-	// If we got an error from go/build about package not found,
-	// it contains the directories from $GOROOT and $GOPATH that
-	// were searched. Add to that message the vendor directories
-	// that were searched.
-	if err != nil && len(vendorSearch) > 0 {
-		// NOTE(rsc): The direct text manipulation here is fairly awful,
-		// but it avoids defining new go/build API (an exported error type)
-		// late in the Go 1.5 release cycle. If this turns out to be a more general
-		// problem we could define a real error type when the decision can be
-		// considered more carefully.
-		text := err.Error()
-		if strings.Contains(text, "cannot find package \"") && strings.Contains(text, "\" in any of:\n\t") {
-			old := strings.SplitAfter(text, "\n")
-			lines := []string{old[0]}
-			for _, dir := range vendorSearch {
-				lines = append(lines, "\t"+dir+" (vendor tree)\n")
-			}
-			lines = append(lines, old[1:]...)
-			err = errors.New(strings.Join(lines, ""))
+	if *since == "last" {
+		r.Since, err = r.NewestModTime()
+		if err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
 	}
-	bp.ImportPath = importPath
-	if gobin != "" {
-		bp.BinDir = gobin
+	if *listFormat != "" {
+		*list = true
 	}
-	if err == nil && bp.ImportComment != "" && bp.ImportComment != path && !strings.Contains(path, "/vendor/") {
-		err = fmt.Errorf("code in directory %s expects import %q", bp.Dir, bp.ImportComment)
+	readOnly := *verify || *verifyMan || *checkDupes || *jsonOut || *dotOut || *list || *report || *why != "" || *whyAll != "" || *dryRun
+	if *clean && readOnly && !*dryRun {
+		fmt.Fprintln(os.Stderr, "flag -clean can only be used when copying packages, or with -n")
+		exit(2)
 	}
-	p.copyBuild(bp)
-	if p.Standard {
-		return p
+	if !readOnly {
+		release, err := r.Lock(context.Background(), *lockWait)
+		if err != nil {
+			c.PrintError("", err)
+			exit(1)
+		}
+		unlock = release
+		defer unlock()
 	}
-	loadDeps(p, stk, err)
-	if p.Error != nil && len(importPos) > 0 {
-		pos := importPos[0]
-		pos.Filename = shortPath(pos.Filename)
-		p.Error.Pos = pos.String()
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
-	return p
-}
-
-// loadDeps loads p's deps
-// it omits the standard library
-func loadDeps(p *Package, stk *importStack, err error) {
-	if err != nil {
-		p.Error = &PackageError{
-			ImportStack: stk.copy(),
-			Err:         err.Error(),
+	if *clean {
+		if err := r.Clean(); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		return
 	}
-
-	// Check for case-insensitive collision of input files.
-	// To avoid problems on case-insensitive files, we reject any package
-	// where two different input files have equal names under a case-insensitive
-	// comparison.
-	f1, f2 := foldDup(stringList(
-		p.GoFiles,
-		p.CgoFiles,
-		p.IgnoredGoFiles,
-		p.CFiles,
-		p.CXXFiles,
-		p.MFiles,
-		p.HFiles,
-		p.SFiles,
-		p.SysoFiles,
-		p.SwigFiles,
-		p.SwigCXXFiles,
-		p.TestGoFiles,
-		p.XTestGoFiles,
-	))
-	if f1 != "" {
-		p.Error = &PackageError{
-			ImportStack: stk.copy(),
-			Err:         fmt.Sprintf("case-insensitive file name collision: %q and %q", f1, f2),
+	if *from != "" {
+		deps, err := r.ReadLockfile(*from)
+		if err != nil {
+			c.PrintError("", err)
+			exit(1)
+		}
+		if err := r.Copy(ctx, deps); err != nil {
+			c.PrintError("", err)
+			exit(1)
+		}
+		if *manif && !*dryRun {
+			if err := r.WriteManifest(deps, nil); err != nil {
+				c.PrintError("", err)
+				exit(1)
+			}
 		}
 		return
 	}
-
-	// Build list of imported packages and full dependency list.
-	deps := make(map[string]*Package)
-	for i, path := range stringList(p.Imports, p.TestImports, p.XTestImports) {
-		if path == "C" {
-			continue
-		}
-		if build.IsLocalImport(path) {
-			p.Error = &PackageError{
-				ImportStack: stk.copy(),
-				Err:         fmt.Sprintf("local import %q in non-local package", path),
-			}
-			pos := p.Package.ImportPos[path]
-			if len(pos) > 0 {
-				p.Error.Pos = pos[0].String()
-			}
-			return
+	if *verifyMan {
+		if err := r.VerifyManifest(ctx); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		p1 := loadImport(path, p.Dir, p, stk, p.Package.ImportPos[path])
-		path = p1.ImportPath
-		if i < len(p.Imports) {
-			p.Imports[i] = path
+		return
+	}
+	if *checkDupes {
+		dupes, err := r.Duplicates()
+		if err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		if p1.Standard {
-			continue
+		for _, d := range dupes {
+			fmt.Printf("duplicate copies of %s:\n\t%s\n", d.ImportPath, strings.Join(d.Dirs, "\n\t"))
 		}
-		deps[path] = p1
-		for _, dep := range p1.deps {
-			deps[dep.ImportPath] = dep
+		if len(dupes) > 0 {
+			exit(1)
 		}
+		return
 	}
-	p.loadedDeps = true
 
-	depErrors := false
-	depPaths := make([]string, 0, len(deps))
-	for path, p1 := range deps {
-		depPaths = append(depPaths, path)
-		p.deps = append(p.deps, p1)
-		if p1.Error != nil {
-			depErrors = true
+	roots, deps, err := r.Resolve(ctx, patterns)
+	if err != nil {
+		c.PrintError("", err)
+		if ctx.Err() != nil {
+			exit(1)
 		}
+		exit(2)
 	}
-	sort.Strings(depPaths)
-	sort.Sort(byImportPath(p.deps))
-
-	// In the absence of errors lower in the dependency tree,
-	// check for case-insensitive collisions of import paths.
-	if !depErrors {
-		dep1, dep2 := foldDup(depPaths)
-		if dep1 != "" {
-			p.Error = &PackageError{
-				ImportStack: stk.copy(),
-				Err:         fmt.Sprintf("case-insensitive import collision: %q and %q", dep1, dep2),
-			}
-			return
-		}
+	if code := checkMatches(c, patterns, r.UnmatchedPatterns(), len(roots), *strict, *allowEmpty); code != 0 {
+		exit(code)
 	}
-}
-
-var isDirCache = map[string]bool{}
-
-func isDir(path string) bool {
-	result, ok := isDirCache[path]
-	if ok {
-		return result
+	ok := true
+	cycles := vendoring.ImportCycles(roots)
+	for _, cycle := range cycles {
+		c.PrintError(cycle[0], fmt.Errorf("import cycle not allowed: %s -> %s", strings.Join(cycle, " -> "), cycle[0]))
+		ok = false
 	}
-
-	fi, err := os.Stat(path)
-	result = err == nil && fi.IsDir()
-	isDirCache[path] = result
-	return result
-}
-
-// vendoredImportPath returns the expansion of path when it appears in parent.
-// If parent is x/y/z, then path might expand to x/y/z/vendor/path, x/y/vendor/path,
-// x/vendor/path, vendor/path, or else stay x/y/z if none of those exist.
-// vendoredImportPath returns the expanded path or, if no expansion is found, the original.
-// If no epxansion is found, vendoredImportPath also returns a list of vendor directories
-// it searched along the way, to help prepare a useful error message should path turn
-// out not to exist.
-// It skips paths that match the patterns in skipVendor.
-func vendoredImportPath(parent *Package, path string) (found string, searched []string) {
-	if parent == nil {
-		return path, nil
-	}
-	for _, match := range skipVendor {
-		if match(path) {
-			return path, nil
-		}
-	}
-	dir := filepath.Clean(parent.Dir)
-	root := filepath.Clean(parent.Root)
-	if !strings.HasPrefix(dir, root) || len(dir) <= len(root) || dir[len(root)] != filepath.Separator {
-		log.Printf("invalid vendoredImportPath: dir=%q root=%q separator=%q", dir, root, string(filepath.Separator))
-		os.Exit(1)
-	}
-	if !inCWD(dir) {
-		// We consider vendored packages only for the root set
-		// we're trying to operate on, not its dependencies.
-		return path, nil
-	}
-	vpath := "vendor/" + path
-	for i := len(dir); i >= len(root); i-- {
-		if i < len(dir) && dir[i] != filepath.Separator {
-			continue
-		}
-		// Note: checking for the vendor directory before checking
-		// for the vendor/path directory helps us hit the
-		// isDir cache more often. It also helps us prepare a more useful
-		// list of places we looked, to report when an import is not found.
-		if !isDir(filepath.Join(dir[:i], "vendor")) {
-			continue
-		}
-		targ := filepath.Join(dir[:i], vpath)
-		if isDir(targ) {
-			// We started with parent's dir c:\gopath\src\foo\bar\baz\quux\xyzzy.
-			// We know the import path for parent's dir.
-			// We chopped off some number of path elements and
-			// added vendor\path to produce c:\gopath\src\foo\bar\baz\vendor\path.
-			// Now we want to know the import path for that directory.
-			// Construct it by chopping the same number of path elements
-			// (actually the same number of bytes) from parent's import path
-			// and then append /vendor/path.
-			chopped := len(dir) - i
-			if chopped == len(parent.ImportPath)+1 {
-				// We walked up from c:\gopath\src\foo\bar
-				// and found c:\gopath\src\vendor\path.
-				// We chopped \foo\bar (length 8) but the import path is "foo/bar" (length 7).
-				// Use "vendor/path" without any prefix.
-				return vpath, nil
-			}
-			return parent.ImportPath[:len(parent.ImportPath)-chopped] + "/" + vpath, nil
+	for _, pkg := range append(roots, deps...) {
+		if pkg.Error != nil && pkg.Error.IsImportCycle() && len(cycles) > 0 {
+			continue // reported above
+		}
+		if pkg.Error != nil {
+			c.PrintError(pkg.ImportPath, pkg.Error)
+			ok = false
+		}
+		if pkg.Standard {
+			c.PrintError(pkg.ImportPath, fmt.Errorf("package %s is in the standard library", pkg.ImportPath))
+			ok = false
 		}
-		// Note the existence of a vendor directory in case path is not found anywhere.
-		searched = append(searched, targ)
-	}
-	return path, searched
-}
-
-// A PackageError describes an error loading information about a package.
-type PackageError struct {
-	ImportStack   []string // shortest path from package named on command line to this one
-	Pos           string   // position of error
-	Err           string   // the error itself
-	isImportCycle bool     // the error is an import cycle
-	hard          bool     // whether the error is soft or hard; soft errors are ignored in some places
-}
-
-func (p *PackageError) Error() string {
-	// Import cycles deserve special treatment.
-	if p.isImportCycle {
-		return fmt.Sprintf("%s\npackage %s\n", p.Err, strings.Join(p.ImportStack, "\n\timports "))
-	}
-	if p.Pos != "" {
-		// Omit import stack.  The full path to the file where the error
-		// is the most important thing.
-		return p.Pos + ": " + p.Err
 	}
-	if len(p.ImportStack) == 0 {
-		return p.Err
+	if !ok {
+		if *errorsJSON {
+			vendoring.WriteErrorsJSON(os.Stdout, append(roots, deps...))
+		}
+		c.PrintError("", errors.New("error(s) loading dependencies"))
+		if !*keepGoing {
+			exit(1)
+		}
+		// Carry on with what loaded, but fail in the end.
+		defer exit(1)
+		deps = vendoring.Loaded(deps)
 	}
-	return "package " + strings.Join(p.ImportStack, "\n\timports ") + ": " + p.Err
-}
-
-// assumes path and cwd are clean
-func inCWD(path string) bool {
-	return path == cwd || strings.HasPrefix(path, cwd+string(os.PathSeparator))
-}
-
-func matchPackagesInFS(pattern string) []string {
-	// Find directory to begin the scan.
-	// Could be smarter but this one optimization
-	// is enough for now, since ... is usually at the
-	// end of a path.
-	i := strings.Index(pattern, "...")
-	dir, _ := pathpkg.Split(pattern[:i])
-
-	// pattern begins with ./ or ../.
-	// path.Clean will discard the ./ but not the ../.
-	// We need to preserve the ./ for pattern matching
-	// and in the returned import paths.
-	prefix := ""
-	if strings.HasPrefix(pattern, "./") {
-		prefix = "./"
-	}
-	match := matchPattern(pattern)
-
-	var pkgs []string
-	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
-		if err != nil || !fi.IsDir() {
-			return nil
-		}
-		if path == dir {
-			// filepath.Walk starts at dir and recurses. For the recursive case,
-			// the path is the result of filepath.Join, which calls filepath.Clean.
-			// The initial case is not Cleaned, though, so we do this explicitly.
-			//
-			// This converts a path like "./io/" to "io". Without this step, running
-			// "cd $GOROOT/src; go list ./io/..." would incorrectly skip the io
-			// package, because prepending the prefix "./" to the unclean path would
-			// result in "././io", and match("././io") returns false.
-			path = filepath.Clean(path)
+	if !*quiet && !*verify {
+		for _, pat := range r.UnmatchedUpdates() {
+			c.PrintWarning("", "-u pattern %s matched no packages", pat)
 		}
-
-		// Avoid .foo, _foo, and testdata directory trees, but do not avoid "." or "..".
-		_, elem := filepath.Split(path)
-		dot := strings.HasPrefix(elem, ".") && elem != "." && elem != ".."
-		if dot || strings.HasPrefix(elem, "_") || elem == "testdata" {
-			return filepath.SkipDir
+	}
+	if !*quiet {
+		for _, cf := range r.Conflicts(roots) {
+			c.PrintWarning(cf.ImportPath, "conflicting copies of %s:\n\t%s", cf.ImportPath, strings.Join(cf.Dirs, "\n\t"))
 		}
-
-		name := prefix + filepath.ToSlash(path)
-		if !match(name) {
-			return nil
+		for _, p := range vendoring.Unbuildable(deps) {
+			c.PrintWarning(p.ImportPath, "package %s has no Go files to build", p.ImportPath)
 		}
-		if _, err = build.ImportDir(path, 0); err != nil {
-			if _, noGo := err.(*build.NoGoError); !noGo {
-				log.Print(err)
+		if *multiMajor {
+			for _, mc := range r.MultipleMajors(roots) {
+				c.PrintWarning(mc.Base, "%s is vendored at more than one major version: %s", mc.Base, strings.Join(mc.ImportPaths, ", "))
 			}
-			return nil
 		}
-		pkgs = append(pkgs, name)
-		return nil
-	})
-	return pkgs
-}
-
-// reusePackage reuses package p to satisfy the import at the top
-// of the import stack stk.  If this use causes an import loop,
-// reusePackage updates p's error information to record the loop.
-func reusePackage(p *Package, stk *importStack) *Package {
-	// (all the recursion below happens before p.loadedDeps gets set).
-	if p.loadedDeps {
-		if p.Error == nil {
-			p.Error = &PackageError{
-				ImportStack:   stk.copy(),
-				Err:           "import cycle not allowed",
-				isImportCycle: true,
-			}
+		for _, cf := range r.Shadows(roots, deps) {
+			c.PrintWarning(cf.ImportPath, "dependency %s shadows a package in the current directory:\n\t%s", cf.ImportPath, strings.Join(cf.Dirs, "\n\t"))
 		}
 	}
-	// Don't rewrite the import stack in the error if we have an import cycle.
-	// If we do, we'll lose the path that describes the cycle.
-	if p.Error != nil && !p.Error.isImportCycle && stk.shorterThan(p.Error.ImportStack) {
-		p.Error.ImportStack = stk.copy()
-	}
-	return p
-}
-
-// matchPattern(pattern)(name) reports whether
-// name matches pattern.  Pattern is a limited glob
-// pattern in which '...' means 'any string' and there
-// is no other special syntax.
-func matchPattern(pattern string) func(name string) bool {
-	re := regexp.QuoteMeta(pattern)
-	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
-	// Special case: foo/... matches foo too.
-	if strings.HasSuffix(re, `/.*`) {
-		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
-	}
-	reg := regexp.MustCompile(`^` + re + `$`)
-	return reg.MatchString
-}
 
-func copyDep(pkg *Package) {
-	if *verbose {
-		fmt.Println("copy", pkg.ImportPath)
+	if *why != "" || *whyAll != "" {
+		path, chains := *why, [][]string(nil)
+		if *whyAll != "" {
+			path, chains = *whyAll, r.ImportChains(roots, *whyAll)
+		} else if chain := r.ShortestChain(roots, *why); chain != nil {
+			chains = [][]string{chain}
+		}
+		if err := vendoring.WriteChains(os.Stdout, chains, path); err != nil {
+			c.PrintError("", err)
+			exit(1)
+		}
+		if len(chains) == 0 {
+			exit(1)
+		}
+		return
 	}
-	dstRoot := filepath.Join("vendor", filepath.FromSlash(pkg.ImportPath))
-	err := os.RemoveAll(dstRoot)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+
+	if *jsonOut {
+		if err := r.WriteGraphJSON(os.Stdout, deps, r.Vendored(roots)); err != nil {
+			c.PrintError("", err)
+			exit(1)
+		}
 		return
 	}
-	filepath.Walk(pkg.Dir, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return nil
+
+	if *dotOut {
+		if err := vendoring.WriteGraphDot(os.Stdout, roots); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
+		return
+	}
 
-		// Avoid .foo, _foo, and testdata directory trees, but do not avoid "." or "..".
-		_, elem := filepath.Split(path)
-		dot := strings.HasPrefix(elem, ".") && elem != "." && elem != ".."
-		if dot || strings.HasPrefix(elem, "_") || elem == "testdata" {
-			if fi.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
+	if *list {
+		if err := vendoring.WriteList(os.Stdout, deps, *listFormat); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
+		return
+	}
 
-		rel, _ := filepath.Rel(pkg.Dir, path)
-		dst := filepath.Join(dstRoot, rel)
-		if fi.IsDir() {
-			err = os.MkdirAll(dst, 0777)
-		} else {
-			err = copyFile(dst, path)
+	if *report {
+		if err := r.WriteReport(os.Stdout, deps, r.Vendored(roots)); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if *verify {
+		if err := r.Verify(ctx, deps); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		return nil
-	})
-}
+		return
+	}
 
-func copyFile(dst, src string) error {
-	sf, err := os.Open(src)
-	if err != nil {
-		return err
+	if err := r.Copy(ctx, deps); err != nil {
+		c.PrintError("", err)
+		exit(1)
 	}
-	defer sf.Close()
-	df, err := os.Create(dst)
-	if err != nil {
-		return err
+	if !*quiet {
+		c.PrintSummary(r.Summary(r.Vendored(roots)))
 	}
-	_, err = io.Copy(df, sf)
-	if err != nil {
-		df.Close()
-		return err
+	if *gitIgnore && !*quiet {
+		if file, pat := r.GitIgnored(); file != "" {
+			c.PrintWarning("", "vendor directory %s is ignored by pattern %s in %s, so git won't commit it", *output, pat, file)
+		}
 	}
-	return df.Close()
-}
-
-func splitList(path string) []string {
-	if path == "" {
-		return []string{}
+	if *cgoReport {
+		vendoring.WriteCgoReport(os.Stdout, append(deps, r.Vendored(roots)...))
 	}
-	return strings.Split(path, ":")
-}
-
-type byImportPath []*Package
-
-func (a byImportPath) Len() int           { return len(a) }
-func (a byImportPath) Less(i, j int) bool { return a[i].ImportPath < a[j].ImportPath }
-func (a byImportPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-
-// hasPathPrefix reports whether the path s begins with the
-// elements in prefix.
-func hasPathPrefix(s, prefix string) bool {
-	switch {
-	default:
-		return false
-	case len(s) == len(prefix):
-		return s == prefix
-	case len(s) > len(prefix):
-		if prefix != "" && prefix[len(prefix)-1] == '/' {
-			return strings.HasPrefix(s, prefix)
+	if *manif && !*dryRun {
+		if err := r.WriteManifest(deps, r.Vendored(roots)); err != nil {
+			c.PrintError("", err)
+			exit(1)
 		}
-		return s[len(prefix)] == '/' && s[:len(prefix)] == prefix
 	}
 }
 
-// shortPath returns an absolute or relative name for path, whatever is shorter.
-func shortPath(path string) string {
-	if rel, err := filepath.Rel(cwd, path); err == nil && len(rel) < len(path) {
-		return rel
-	}
-	return path
-}
+// unlock releases the lock on the vendor tree, if held.
+var unlock = func() {}
 
-// stringList's arguments should be a sequence of string or []string values.
-// stringList flattens them into a single []string.
-func stringList(args ...interface{}) []string {
-	var x []string
-	for _, arg := range args {
-		switch arg := arg.(type) {
-		case []string:
-			x = append(x, arg...)
-		case string:
-			x = append(x, arg)
-		default:
-			panic("stringList: invalid argument of type " + fmt.Sprintf("%T", arg))
-		}
-	}
-	return x
+// exit releases the lock on the vendor tree
+// and exits with the given status code.
+func exit(code int) {
+	unlock()
+	os.Exit(code)
 }
 
-// toFold returns a string with the property that
-// strings.EqualFold(s, t) iff toFold(s) == toFold(t)
-// This lets us test a large set of strings for fold-equivalent
-// duplicates without making a quadratic number of calls
-// to EqualFold. Note that strings.ToUpper and strings.ToLower
-// have the desired property in some corner cases.
-func toFold(s string) string {
-	// Fast path: all ASCII, no upper case.
-	// Most paths look like this already.
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if c >= utf8.RuneSelf || 'A' <= c && c <= 'Z' {
-			goto Slow
-		}
-	}
-	return s
-
-Slow:
-	var buf bytes.Buffer
-	for _, r := range s {
-		// SimpleFold(x) cycles to the next equivalent rune > x
-		// or wraps around to smaller values. Iterate until it wraps,
-		// and we've found the minimum value.
-		for {
-			r0 := r
-			r = unicode.SimpleFold(r0)
-			if r <= r0 {
-				break
-			}
+// checkMatches reports package patterns that matched nothing,
+// given the patterns that matched no packages, unmatched, and
+// the number of packages all of them matched, nroots. Under
+// -strict, each pattern in unmatched is an error. Otherwise,
+// if nothing matched at all, vexp warns, and fails unless
+// -allow-empty is given. It returns the status to exit with,
+// or 0 to carry on.
+func checkMatches(c *vendoring.Config, patterns, unmatched []string, nroots int, strict, allowEmpty bool) int {
+	if strict {
+		for _, pat := range unmatched {
+			c.PrintError("", fmt.Errorf("pattern %s matched no packages", pat))
 		}
-		// Exception to allow fast path above: A-Z => a-z
-		if 'A' <= r && r <= 'Z' {
-			r += 'a' - 'A'
+		if len(unmatched) > 0 {
+			return 1
 		}
-		buf.WriteRune(r)
 	}
-	return buf.String()
-}
-
-// foldDup reports a pair of strings from the list that are
-// equal according to strings.EqualFold.
-// It returns "", "" if there are no such strings.
-func foldDup(list []string) (string, string) {
-	clash := map[string]string{}
-	for _, s := range list {
-		fold := toFold(s)
-		if t := clash[fold]; t != "" {
-			if s > t {
-				s, t = t, s
-			}
-			return s, t
+	if nroots == 0 {
+		c.PrintWarning("", "%s matched no packages", strings.Join(patterns, " "))
+		if !allowEmpty {
+			return 1
 		}
-		clash[fold] = s
 	}
-	return "", ""
+	return 0
 }
 
-func defaultBuildContext() build.Context {
-	c := build.Default
-	c.UseAllFiles = true
-	return c
+// splitList splits a list of patterns given to -u, -exclude,
+// or -add. They can be separated by colons, commas, or the
+// system's list separator, which is a semicolon on Windows.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(c rune) bool {
+		return c == ':' || c == ',' || c == os.PathListSeparator
+	})
 }
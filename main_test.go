@@ -1,204 +1,126 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"github.com/kr/vexp/vendoring"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 )
 
-func TestFindDeps(t *testing.T) {
-	findDeps := []struct {
-		root, update, want, tab string
-		wantErr                 bool
+func TestLevelFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want levelFlag
 	}{
-		{
-			root: "p",
-			want: "d",
-			tab: `
-				p/p.go: package p; import _ "d"
-				d/d.go: package d
-			`,
-		},
-		{
-			root: "p",
-			want: "",
-			tab: `
-				p/p.go:          package p; import _ "d"
-				p/vendor/d/d.go: package d
-				d/d.go:          package d
-			`,
-		},
-		{
-			root:   "p",
-			update: "d",
-			want:   "d",
-			tab: `
-				p/p.go:          package p; import _ "d"
-				p/vendor/d/d.go: package d
-				d/d.go:          package d
-			`,
-		},
-		{
-			root: "p",
-			want: "d q",
-			tab: `
-				p/p.go: package p; import _ "q"
-				q/q.go: package q; import _ "d"
-				d/d.go: package d
-			`,
-		},
-		{
-			root: "p",
-			want: "d q",
-			tab: `
-				p/p.go:          package p; import _ "q"
-				q/q.go:          package q; import _ "d"
-				q/vendor/d/d.go: package d
-				d/d.go:          package d
-			`,
-		},
-		{
-			root: "p",
-			want: "d", // d has Error set
-			tab: `
-				p/p.go: package p; import _ "d"
-			`,
-			wantErr: true,
-		},
-		{
-			root: "p",
-			want: "d q", // d has Error set
-			tab: `
-				p/p.go:          package p; import _ "q"
-				q/q.go:          package q; import _ "d"
-				q/vendor/d/d.go: package d
-			`,
-			wantErr: true,
-		},
-		{
-			// copy test dependencies
-			root: "p",
-			want: "pt q qt xt",
-			tab: `
-				p/p.go:      package p;      import _ "q"
-				p/p_test.go: package p;      import _ "pt"
-				p/x_test.go: package p_test; import _ "xt"
-				q/q.go:      package q
-				q/q_test.go: package q;      import _ "qt"
-				pt/pt.go:    package pt
-				xt/xt.go:    package xt
-				qt/qt.go:    package qt
-			`,
-		},
-		{
-			root: "p",
-			want: "d e",
-			tab: `
-				p/p_darwin.go: package p; import _ "d"
-				p/p_linux.go:  package p; import _ "e"
-				d/d.go: package d
-				e/e.go: package e
-			`,
-		},
-		{
-			root: "p",
-			want: "",
-			tab: `
-				p/p.go:   package p; import _ "./d"
-				p/d/d.go: package d
-			`,
-			wantErr: true,
-		},
+		{nil, 0},
+		{[]string{"-v"}, 1},
+		{[]string{"-v", "-v"}, 2},
+		{[]string{"-v=3"}, 3},
+		{[]string{"-v=2", "-v"}, 3},
 	}
-
-	for _, test := range findDeps {
-		paths := strings.Fields(test.root)
-		clean := setup(t, paths[0], test.tab)
-		defer clean()
-		skipVendor = flagUPats(test.update)
-		pkgs := packages(paths)
-		deps := dependencies(pkgs)
-		if got := anyErr(append(pkgs, deps...)); got != test.wantErr {
-			t.Errorf("dependencies(packages(%q)) error = %v want %v", test.root, got, test.wantErr)
-			t.Logf("flag -u=%q", test.update)
-			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
-		}
-		got := names(deps)
-		want := strings.Fields(test.want)
-		if len(want) == 0 {
-			want = nil
+	for _, test := range tests {
+		var l levelFlag
+		fs := flag.NewFlagSet("vexp", flag.ContinueOnError)
+		fs.Var(&l, "v", "")
+		if err := fs.Parse(test.args); err != nil {
+			t.Errorf("parse %q: %v", test.args, err)
+			continue
 		}
-		if !reflect.DeepEqual(got, want) {
-			t.Errorf("dependencies(packages(%q)) = %v want %v", test.root, got, want)
-			t.Logf("flag -u=%q", test.update)
-			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
+		if l != test.want {
+			t.Errorf("parse %q = %d want %d", test.args, l, test.want)
 		}
-		clean()
 	}
 }
 
-func names(ps []*Package) (a []string) {
-	for _, p := range ps {
-		a = append(a, p.ImportPath)
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a:b/...", []string{"a", "b/..."}},
+		{"a,b/...", []string{"a", "b/..."}},
+		{"a:b,c", []string{"a", "b", "c"}},
+		{"a,,b:", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		if got := splitList(test.list); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitList(%q) = %q want %q", test.list, got, test.want)
+		}
 	}
-	return a
 }
 
-func anyErr(ps []*Package) bool {
-	for _, p := range ps {
-		if p.Error != nil {
-			return true
+func TestCheckMatches(t *testing.T) {
+	tests := []struct {
+		patterns, unmatched []string
+		nroots              int
+		strict, allowEmpty  bool
+		want                int
+		wantOut             string
+	}{
+		{[]string{"./..."}, nil, 2, false, false, 0, ""},
+		{[]string{"./..."}, []string{"./..."}, 0, false, false, 1, "warning: ./... matched no packages\n"},
+		{[]string{"./..."}, []string{"./..."}, 0, false, true, 0, "warning: ./... matched no packages\n"},
+		{[]string{"./a/...", "./b/..."}, []string{"./b/..."}, 1, false, false, 0, ""},
+		{[]string{"./a/...", "./b/..."}, []string{"./b/..."}, 1, true, false, 1, "pattern ./b/... matched no packages\n"},
+		{[]string{"./..."}, []string{"./..."}, 0, true, false, 1, "pattern ./... matched no packages\n"},
+		{[]string{"./..."}, nil, 2, true, false, 0, ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		c := vendoring.New(".")
+		c.Stderr = &buf
+		got := checkMatches(c, test.patterns, test.unmatched, test.nroots, test.strict, test.allowEmpty)
+		if got != test.want || buf.String() != test.wantOut {
+			t.Errorf("checkMatches(%q, %q, %d, strict %v, allowEmpty %v) = %d, %q want %d, %q",
+				test.patterns, test.unmatched, test.nroots, test.strict, test.allowEmpty,
+				got, buf.String(), test.want, test.wantOut)
 		}
 	}
-	return false
 }
 
-// setup sets up a test directory using the filesystem table
-// described in tab.
-// It sets buildContext.GOPATH to a new empty directory,
-// populates that workspace with the source files and contents
-// in tab, and sets cwd to the directory containing
-// the first file listed in tab.
-// clean resets buildContext.GOPATH and cwd to their
-// previous values and removes the temporary directory.
-func setup(t *testing.T, start, tab string) (clean func()) {
+func TestAllowEmpty(t *testing.T) {
+	if args := os.Getenv("VEXP_TEST_MAIN"); args != "" {
+		// Run as vexp, in the subprocesses started below.
+		os.Args = strings.Fields(args)
+		main()
+		os.Exit(0)
+	}
 	wksp, err := ioutil.TempDir("", "vexp-test-")
 	if err != nil {
-		t.Fatal("setup", err)
+		t.Fatal(err)
 	}
-	src := filepath.Join(wksp, "src")
-
-	saveCwd := cwd
-	saveGOPATH := buildContext.GOPATH
-	buildContext.GOPATH = wksp
-	cwd = filepath.Join(src, filepath.FromSlash(start))
-
-	for _, field := range strings.Split(tab, "\n") {
-		field = strings.TrimSpace(field)
-		i := strings.Index(field, ":")
-		if i < 1 {
-			continue
-		}
-		path := filepath.Join(src, filepath.FromSlash(strings.TrimSpace(field[:i])))
-		body := strings.TrimSpace(field[i+1:]) + "\n"
-		err = os.MkdirAll(filepath.Dir(path), 0777)
-		if err == nil {
-			err = ioutil.WriteFile(path, []byte(body), 0666)
+	defer os.RemoveAll(wksp)
+	dir := filepath.Join(wksp, "src", "p")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args string
+		want int
+	}{
+		{"vexp", 1},
+		{"vexp -allow-empty", 0},
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAllowEmpty$")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOPATH="+wksp, "VEXP_TEST_MAIN="+test.args)
+		out, err := cmd.CombinedOutput()
+		code := 0
+		if e, ok := err.(*exec.ExitError); ok {
+			code = e.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
 		}
-		if err != nil {
-			os.RemoveAll(wksp)
-			t.Fatal("setup", err)
+		if code != test.want || !strings.Contains(string(out), "./... matched no packages") {
+			t.Errorf("%s: exit status %d, output %q; want status %d and a warning", test.args, code, out, test.want)
 		}
 	}
-
-	return func() {
-		cwd = saveCwd
-		buildContext.GOPATH = saveGOPATH
-		os.RemoveAll(wksp)
-		packageCache = map[string]*Package{}
-		skipVendor = nil
-	}
 }
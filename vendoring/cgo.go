@@ -0,0 +1,106 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cgoIncludeDirs returns the directories named by -I
+// in pkg's #cgo flags, as absolute paths.
+func cgoIncludeDirs(pkg *Package) []string {
+	if pkg.Package == nil {
+		return nil
+	}
+	var dirs []string
+	flags := stringList(pkg.CgoCFLAGS, pkg.CgoCPPFLAGS, pkg.CgoCXXFLAGS)
+	for i := 0; i < len(flags); i++ {
+		var dir string
+		switch {
+		case flags[i] == "-I" && i+1 < len(flags):
+			i++
+			dir = flags[i]
+		case strings.HasPrefix(flags[i], "-I") && flags[i] != "-I":
+			dir = flags[i][len("-I"):]
+		default:
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(pkg.Dir, dir)
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs
+}
+
+// withIncludes returns deps, plus a pseudo-package for each
+// cgo include directory that one of them names outside its
+// own directory but inside its repository, sorted by import
+// path. Copying the pseudo-package copies the directory to
+// the same place relative to the vendored package as it has
+// relative to the source.
+// It prints a warning for each include directory outside
+// the package's repository, which vexp can't copy.
+func (r *Resolver) withIncludes(deps []*Package) []*Package {
+	var incs []*Package
+	seen := make(map[string]bool)
+	for _, pkg := range deps {
+		for _, dir := range cgoIncludeDirs(pkg) {
+			if dir == filepath.Clean(pkg.Dir) || inDir(dir, pkg.Dir) || seen[dir] {
+				continue
+			}
+			root := repoRoot(pkg.Dir, pkg.SrcRoot)
+			if dir != root && !inDir(dir, root) {
+				r.warnf(r.stderr(), pkg.ImportPath, "cgo include directory %s of %s is outside its repository; the vendored copy may not build", dir, pkg.ImportPath)
+				continue
+			}
+			if !r.isDir(dir) {
+				continue
+			}
+			seen[dir] = true
+			rel, err := filepath.Rel(pkg.Dir, dir)
+			if err != nil {
+				continue
+			}
+			incs = append(incs, &Package{
+				Package: &build.Package{
+					ImportPath: pathpkg.Join(pkg.ImportPath, filepath.ToSlash(rel)),
+					Dir:        dir,
+					SrcRoot:    pkg.SrcRoot,
+				},
+				include: true,
+			})
+		}
+	}
+	if len(incs) == 0 {
+		return deps
+	}
+	a := append(append([]*Package{}, deps...), incs...)
+	sort.Sort(byImportPath(a))
+	return a
+}
+
+// WriteCgoReport writes to w a line for each package
+// in pkgs that uses cgo, sorted by import path.
+func WriteCgoReport(w io.Writer, pkgs []*Package) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, p := range pkgs {
+		if p.usesCgo && !seen[p.ImportPath] {
+			seen[p.ImportPath] = true
+			paths = append(paths, p.ImportPath)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintln(w, "cgo", path)
+	}
+}
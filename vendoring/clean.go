@@ -0,0 +1,94 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Clean removes everything in the output directory, other
+// than the lock file, so that the next Copy starts afresh.
+// Under Config.DryRun, it prints what it would remove instead.
+//
+// As a safety check, Clean refuses, returning an error, if the
+// output directory holds a version control directory, such as
+// .git, at any depth, since vexp never copies those, or a Go
+// file importing a package of Config.Dir by its import path,
+// as vendored code never does, so the directory holds
+// something other than vendored packages, such as the
+// packages of Config.Dir itself.
+// The output directory is always inside Config.Dir.
+func (r *Resolver) Clean() error {
+	root := filepath.Join(r.cwd, r.outDir)
+	fis, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cwdPath := r.dirImportPath(r.cwd)
+	fset := token.NewFileSet()
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, vcs := range vcsDirs {
+			if fi.Name() == vcs {
+				return fmt.Errorf("not cleaning %s: it holds %s, so it isn't just vendored code", r.shortPath(root), r.shortPath(path))
+			}
+		}
+		if cwdPath != "" && !fi.IsDir() && strings.HasSuffix(path, ".go") {
+			if imp := importWithPrefix(fset, path, cwdPath); imp != "" {
+				return fmt.Errorf("not cleaning %s: %s imports %s, so it isn't just vendored code", r.shortPath(root), r.shortPath(path), imp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.cleaned = true
+	for _, fi := range fis {
+		if fi.Name() == LockName {
+			continue
+		}
+		path := filepath.Join(root, fi.Name())
+		if r.DryRun || r.Verbosity >= levelVerbose {
+			e := event{Event: "remove", Dest: path, Message: "remove " + r.shortPath(path)}
+			r.writeEvent(r.stdout(), levelVerbose, e)
+		}
+		if r.DryRun {
+			continue
+		}
+		if err := removeAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importWithPrefix returns the first import path in the Go
+// file name that is prefix or below it, or "" if there is
+// none, or the file can't be parsed.
+func importWithPrefix(fset *token.FileSet, name, prefix string) string {
+	f, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+	if err != nil {
+		return ""
+	}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err == nil && hasPathPrefix(path, prefix) {
+			return path
+		}
+	}
+	return ""
+}
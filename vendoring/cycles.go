@@ -0,0 +1,126 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import "sort"
+
+// ImportCycles returns the import cycles among roots and
+// their dependencies, following only the imports of non-test
+// files, as the go tool does. There is one cycle for each
+// strongly connected component of more than one package in
+// the import graph. Each is a ring of import paths: the first
+// imports the second, and so on, and the last imports the
+// first. A ring starts with the least import path in its
+// component, and takes the shortest way back to it.
+// The cycles are sorted.
+func ImportCycles(roots []*Package) [][]string {
+	// Find the strongly connected components
+	// with Tarjan's algorithm.
+	var (
+		index   = make(map[*Package]int)
+		low     = make(map[*Package]int)
+		onStack = make(map[*Package]bool)
+		stk     []*Package
+		comps   [][]*Package
+	)
+	var visit func(p *Package)
+	visit = func(p *Package) {
+		index[p] = len(index)
+		low[p] = index[p]
+		stk = append(stk, p)
+		onStack[p] = true
+		for _, q := range buildImports(p) {
+			if _, ok := index[q]; !ok {
+				visit(q)
+				if low[q] < low[p] {
+					low[p] = low[q]
+				}
+			} else if onStack[q] && index[q] < low[p] {
+				low[p] = index[q]
+			}
+		}
+		if low[p] != index[p] {
+			return
+		}
+		var comp []*Package
+		for {
+			q := stk[len(stk)-1]
+			stk = stk[:len(stk)-1]
+			onStack[q] = false
+			comp = append(comp, q)
+			if q == p {
+				break
+			}
+		}
+		if len(comp) > 1 {
+			comps = append(comps, comp)
+		}
+	}
+	for _, root := range roots {
+		for _, p := range append([]*Package{root}, root.deps...) {
+			if _, ok := index[p]; !ok {
+				visit(p)
+			}
+		}
+	}
+
+	var cycles [][]string
+	for _, comp := range comps {
+		sort.Sort(byImportPath(comp))
+		cycles = append(cycles, ring(comp))
+	}
+	sort.Sort(byChain(cycles))
+	return cycles
+}
+
+// ring returns the shortest import cycle that starts and ends
+// at comp[0] and stays within comp, a strongly connected component.
+func ring(comp []*Package) []string {
+	in := make(map[*Package]bool)
+	for _, p := range comp {
+		in[p] = true
+	}
+	start := comp[0]
+	from := make(map[*Package]*Package) // importer on the shortest way from start
+	queue := []*Package{start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, q := range buildImports(p) {
+			if q == start {
+				var a []string
+				for ; p != nil; p = from[p] {
+					a = append([]string{p.ImportPath}, a...)
+				}
+				return a
+			}
+			if in[q] && from[q] == nil {
+				from[q] = p
+				queue = append(queue, q)
+			}
+		}
+	}
+	return nil // not reached for a strongly connected component
+}
+
+// buildImports returns the direct imports of p's non-test
+// files, sorted by import path.
+func buildImports(p *Package) []*Package {
+	if p.Package == nil {
+		return nil
+	}
+	isImport := make(map[string]bool)
+	for _, path := range p.Imports {
+		isImport[path] = true
+	}
+	var a []*Package
+	for _, q := range p.imports {
+		if isImport[q.ImportPath] {
+			a = append(a, q)
+		}
+	}
+	sort.Sort(byImportPath(a))
+	return a
+}
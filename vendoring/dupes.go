@@ -0,0 +1,78 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Duplicates scans the existing vendor tree for packages
+// vendored more than once at different levels of nesting,
+// such as vendor/x and vendor/a/vendor/x, which make it
+// ambiguous which copy a build uses.
+// It returns a Conflict for each import path with more than
+// one copy, listing the directories that hold them.
+// A missing vendor tree has no duplicates.
+func (r *Resolver) Duplicates() ([]Conflict, error) {
+	root := filepath.Join(r.cwd, r.outDir)
+	vdir := filepath.Base(r.outDir)
+	dirs := make(map[string][]string)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		_, elem := filepath.Split(path)
+		if path != root && (strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") || elem == "testdata") {
+			return filepath.SkipDir
+		}
+		if path == root || !hasGoFiles(path) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		for i := len(elems) - 1; i >= 0; i-- {
+			if elems[i] == vdir {
+				elems = elems[i+1:]
+				break
+			}
+		}
+		ip := strings.Join(elems, "/")
+		dirs[ip] = append(dirs[ip], path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var a []Conflict
+	for ip, d := range dirs {
+		if len(d) > 1 {
+			sort.Strings(d)
+			a = append(a, Conflict{ImportPath: ip, Dirs: d})
+		}
+	}
+	sort.Sort(byConflictPath(a))
+	return a, nil
+}
+
+// hasGoFiles reports whether dir holds any .go files.
+func hasGoFiles(dir string) bool {
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// listEmbeds records the files that pkgs embed with
+// //go:embed directives, so that walkDep copies them
+// even where it would otherwise leave them out, as in
+// testdata, along with the directories holding them.
+// A pattern that matches a directory embeds the files
+// in its tree, except those named .foo or _foo, as for
+// the go tool, unless the pattern starts with "all:".
+// It warns about each pattern that matches nothing,
+// as the package won't build without its files.
+func (r *Resolver) listEmbeds(pkgs []*Package) {
+	r.embedded = make(map[string]bool)
+	r.embedDirs = make(map[string]bool)
+	for _, p := range pkgs {
+		if p.Package == nil || p.Dir == "" || p.include {
+			continue
+		}
+		dir := filepath.Clean(p.Dir)
+		for _, pat := range r.embedPatterns(p) {
+			all := strings.HasPrefix(pat, "all:")
+			matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(pat, "all:"))))
+			if len(matches) == 0 {
+				r.warnf(r.stderr(), p.ImportPath, "%s: pattern %s in //go:embed matches no files", p.ImportPath, pat)
+				continue
+			}
+			for _, m := range matches {
+				if !inDir(m, dir) {
+					continue
+				}
+				r.embedTree(m, all)
+				for d := filepath.Dir(m); d != dir && inDir(d, dir); d = filepath.Dir(d) {
+					r.embedDirs[d] = true
+				}
+			}
+		}
+	}
+}
+
+// embedPatterns returns the //go:embed patterns of p,
+// including those in its tests, unless they are left out.
+func (r *Resolver) embedPatterns(p *Package) []string {
+	tests := !r.NoTest
+	for _, cat := range r.Strip {
+		tests = tests && cat != "tests"
+	}
+	if !tests {
+		return p.EmbedPatterns
+	}
+	return stringList(p.EmbedPatterns, p.TestEmbedPatterns, p.XTestEmbedPatterns)
+}
+
+// embedTree records name, a file or directory matched by
+// an embed pattern, in r.embedded, along with the tree
+// under it, if a directory. Unless all is set, it leaves
+// out files and directories in the tree named .foo or _foo.
+func (r *Resolver) embedTree(name string, all bool) {
+	filepath.Walk(name, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		elem := filepath.Base(path)
+		if path != name && !all && (strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_")) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		r.embedded[path] = true
+		return nil
+	})
+}
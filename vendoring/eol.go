@@ -0,0 +1,90 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// textExts lists the file name extensions of source files
+// whose line endings Config.NormalizeEOL rewrites.
+// Other files, such as .syso objects, are copied as they are.
+var textExts = map[string]bool{
+	".go":  true,
+	".c":   true,
+	".cc":  true,
+	".cpp": true,
+	".cxx": true,
+	".m":   true,
+	".h":   true,
+	".hh":  true,
+	".hpp": true,
+	".hxx": true,
+	".s":   true,
+	".S":   true,
+}
+
+// normalizesEOL reports whether copying the file name
+// rewrites its CRLF line endings to LF.
+func (r *Resolver) normalizesEOL(name string) bool {
+	return r.NormalizeEOL && textExts[filepath.Ext(name)]
+}
+
+// copyFileEOL is like copyFile, but replaces each CRLF
+// in src with LF, and never makes a link. If sync is set,
+// it flushes dst to stable storage before closing it.
+func copyFileEOL(dst, src string, sync bool) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = df.Write(crlfToLF(b))
+	if err == nil && sync {
+		err = osSync(df)
+	}
+	if err != nil {
+		df.Close()
+		return err
+	}
+	if err = df.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return warning{err}
+	}
+	return copyModTime(dst, fi)
+}
+
+func crlfToLF(b []byte) []byte {
+	return bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+}
+
+// sameCopy reports whether dst has the contents
+// that copying src would give it.
+func (r *Resolver) sameCopy(dst, src string) (bool, error) {
+	if !r.normalizesEOL(src) {
+		return sameContents(dst, src)
+	}
+	db, err := ioutil.ReadFile(dst)
+	if err != nil {
+		return false, err
+	}
+	sb, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(db, crlfToLF(sb)), nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// A loadError describes a package that failed to load,
+// for flag -errors-json.
+type loadError struct {
+	ImportPath    string   // the package that failed
+	ImportStack   []string // shortest path from a root to the package
+	Pos           string   // position of the error, if known
+	Err           string   // the error itself
+	IsImportCycle bool     // the error is an import cycle
+}
+
+// WriteErrorsJSON writes to w a JSON array describing the
+// packages in pkgs that failed to load, in order.
+// A package in the standard library, which can't be
+// vendored, counts as one that failed.
+// It writes an empty array if there are none.
+func WriteErrorsJSON(w io.Writer, pkgs []*Package) error {
+	a := []loadError{}
+	for _, p := range pkgs {
+		switch {
+		case p.Error != nil:
+			a = append(a, loadError{
+				ImportPath:    p.ImportPath,
+				ImportStack:   p.Error.ImportStack,
+				Pos:           p.Error.Pos,
+				Err:           p.Error.Err,
+				IsImportCycle: p.Error.isImportCycle,
+			})
+		case p.Standard:
+			a = append(a, loadError{
+				ImportPath: p.ImportPath,
+				Err:        "package " + p.ImportPath + " is in the standard library",
+			})
+		}
+	}
+	b, err := json.MarshalIndent(a, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
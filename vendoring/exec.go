@@ -0,0 +1,54 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// runHooks runs the shell command Config.Exec once for each
+// of pkgs, in order, with the package's import path and
+// vendored directory in environment variables VEXP_IMPORTPATH
+// and VEXP_DIR. The command runs in cwd, writing to
+// Config.Stdout and Config.Stderr.
+// It stops at the first command that fails, and returns an
+// error saying which package it was for.
+// Cgo include directories get no command.
+func (r *Resolver) runHooks(pkgs []*Package) error {
+	for _, pkg := range pkgs {
+		if pkg.include {
+			continue
+		}
+		cmd := shellCommand(r.ctx, r.Exec)
+		cmd.Dir = r.cwd
+		cmd.Env = append(os.Environ(),
+			"VEXP_IMPORTPATH="+pkg.ImportPath,
+			"VEXP_DIR="+r.vendorDir(pkg),
+		)
+		cmd.Stdout = r.stdout()
+		cmd.Stderr = r.stderr()
+		r.log(r.stderr(), levelVerbose, event{Event: "exec", ImportPath: pkg.ImportPath, Dest: r.vendorDir(pkg), Message: "exec " + pkg.ImportPath})
+		if err := cmd.Run(); err != nil {
+			if r.ctx.Err() != nil {
+				err = r.ctx.Err()
+			}
+			return fmt.Errorf("-exec for %s: %v", pkg.ImportPath, err)
+		}
+	}
+	return nil
+}
+
+// shellCommand returns a Cmd that runs s with the system shell,
+// and is killed if ctx is done first.
+func shellCommand(ctx context.Context, s string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/c", s)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", s)
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// GitIgnored reports whether git ignores the vendor directory,
+// as the .gitignore files of the git repository containing
+// Config.Dir say, along with its info/exclude file, so that the
+// vendored packages would never be committed. If so, it returns
+// the file and pattern that ignore it, or else two empty strings.
+// It reads the files directly rather than running git.
+func (r *Resolver) GitIgnored() (file, pattern string) {
+	root := gitWorkTree(r.cwd)
+	if root == "" {
+		return "", ""
+	}
+	rel, err := filepath.Rel(root, filepath.Join(r.cwd, r.outDir))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", ""
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+
+	// Each directory on the way down to the vendor directory
+	// is checked in turn, since git ignores everything under
+	// an ignored directory. The rules in deeper .gitignore files
+	// come later, and the last rule that matches wins.
+	var rules []gitRule
+	if gitDir := findGitDir(root, ""); gitDir != "" {
+		rules = readGitIgnore(filepath.Join(gitDir, "info", "exclude"), "")
+	}
+	for i := range elems {
+		base := strings.Join(elems[:i], "/")
+		rules = append(rules, readGitIgnore(filepath.Join(root, filepath.FromSlash(base), ".gitignore"), base)...)
+		dir := strings.Join(elems[:i+1], "/")
+		var last *gitRule
+		for j := range rules {
+			if rules[j].match(dir) {
+				last = &rules[j]
+			}
+		}
+		if last != nil && !last.negate {
+			return last.file, last.pattern
+		}
+	}
+	return "", ""
+}
+
+// gitWorkTree returns the directory holding the .git
+// directory or file in dir or its nearest parent
+// that has one, or "" if none does.
+func gitWorkTree(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// A gitRule is a line of a .gitignore file.
+type gitRule struct {
+	file     string   // the .gitignore file
+	pattern  string   // as written, for reports
+	base     string   // slash-separated directory of file, relative to the work tree
+	elems    []string // pattern, split at slashes, without a leading one
+	anchored bool     // whether pattern has a slash, other than at the end
+	negate   bool     // whether pattern starts with !, to include again what it matches
+}
+
+// readGitIgnore reads the rules in the .gitignore file name,
+// whose directory is base, relative to the work tree.
+// A file that can't be read has no rules.
+func readGitIgnore(name, base string) (rules []gitRule) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := gitRule{file: name, pattern: line, base: base}
+		pat := line
+		if strings.HasPrefix(pat, "!") {
+			rule.negate = true
+			pat = pat[1:]
+		} else if strings.HasPrefix(pat, `\`) {
+			pat = pat[1:] // as in \#foo or \!foo
+		}
+		// Only directories are checked, so a trailing
+		// slash, matching only directories, changes nothing.
+		pat = strings.TrimSuffix(pat, "/")
+		rule.anchored = strings.Contains(pat, "/")
+		pat = strings.TrimPrefix(pat, "/")
+		if pat == "" {
+			continue
+		}
+		rule.elems = strings.Split(pat, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match reports whether rule matches the directory
+// dir, a slash-separated path relative to the work tree.
+func (rule *gitRule) match(dir string) bool {
+	if rule.base != "" {
+		if !strings.HasPrefix(dir, rule.base+"/") {
+			return false
+		}
+		dir = dir[len(rule.base)+1:]
+	}
+	elems := strings.Split(dir, "/")
+	if !rule.anchored {
+		// A pattern without a slash matches
+		// a name at any depth.
+		ok, _ := pathpkg.Match(rule.elems[0], elems[len(elems)-1])
+		return ok
+	}
+	return matchElems(rule.elems, elems)
+}
+
+// matchElems reports whether the path elements elems match
+// the pattern elements pats, where ** matches any number of
+// elements, including none.
+func matchElems(pats, elems []string) bool {
+	for len(pats) > 0 {
+		if pats[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pats[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := pathpkg.Match(pats[0], elems[0]); !ok {
+			return false
+		}
+		pats, elems = pats[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
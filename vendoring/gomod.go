@@ -0,0 +1,66 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// listGoMods records, under -copy-gomod, the go.mod file of
+// the module each of pkgs is in, for walkDep to copy into the
+// package's directory, along with the go.sum beside it, if
+// any. It looks for the nearest go.mod above each package's
+// directory, no higher than the root of its repository (see
+// repoRoot). A package with a go.mod of its own is left out,
+// as its directory's go.mod and go.sum are copied anyway, and
+// one mustn't be copied over the other.
+func (r *Resolver) listGoMods(pkgs []*Package) {
+	r.goMods = nil
+	if !r.CopyGoMod {
+		return
+	}
+	r.goMods = make(map[string]string)
+	for _, p := range pkgs {
+		if p.Package == nil || p.Dir == "" || p.include {
+			continue
+		}
+		dir := filepath.Clean(p.Dir)
+		if isFile(filepath.Join(dir, "go.mod")) {
+			continue
+		}
+		root := repoRoot(dir, p.SrcRoot)
+		for d := filepath.Dir(dir); d == root || inDir(d, root); d = filepath.Dir(d) {
+			if name := filepath.Join(d, "go.mod"); isFile(name) {
+				r.goMods[dir] = name
+				break
+			}
+		}
+	}
+}
+
+// goModFiles returns the files listGoMods recorded for
+// the package directory dir, to be copied into it as if
+// they were there, with rel its path relative to the
+// directory walkDep is walking.
+func (r *Resolver) goModFiles(dir, rel string) (a []walkFile) {
+	mod := r.goMods[dir]
+	if mod == "" {
+		return nil
+	}
+	for _, name := range []string{"go.mod", "go.sum"} {
+		src := filepath.Join(filepath.Dir(mod), name)
+		if fi, err := os.Stat(src); err == nil && fi.Mode().IsRegular() {
+			a = append(a, walkFile{filepath.Join(rel, name), src, fi})
+		}
+	}
+	return a
+}
+
+// isFile reports whether name is a regular file.
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A graphPackage describes one package in the
+// dependency graph, for flag -json.
+type graphPackage struct {
+	ImportPath string   // import path, as seen from outside the vendor tree
+	Dir        string   // directory containing package sources
+	Standard   bool     // is this package part of the standard Go library?
+	Imports    []string // import paths used by this package
+	Vendored   bool     // already in the vendor tree, so not copied
+}
+
+// WriteGraphJSON writes a JSON description of deps, which
+// would be copied, and vendored, which are already present,
+// to w, sorted by import path.
+func (r *Resolver) WriteGraphJSON(w io.Writer, deps, vendored []*Package) error {
+	var a []graphPackage
+	seen := make(map[string]bool)
+	add := func(pkg *Package, isVendored bool) {
+		path, _ := r.unvendoredPath(pkg.ImportPath)
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		gp := graphPackage{
+			ImportPath: path,
+			Dir:        pkg.Dir,
+			Standard:   pkg.Standard,
+			Vendored:   isVendored,
+		}
+		for _, imp := range pkg.Imports {
+			imp, _ = r.unvendoredPath(imp)
+			gp.Imports = append(gp.Imports, imp)
+		}
+		a = append(a, gp)
+	}
+	for _, pkg := range deps {
+		add(pkg, false)
+	}
+	for _, pkg := range vendored {
+		add(pkg, true)
+	}
+	sort.Sort(byGraphImportPath(a))
+
+	b, err := json.MarshalIndent(a, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+type byGraphImportPath []graphPackage
+
+func (a byGraphImportPath) Len() int           { return len(a) }
+func (a byGraphImportPath) Less(i, j int) bool { return a[i].ImportPath < a[j].ImportPath }
+func (a byGraphImportPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// WriteGraphDot writes the dependency graph of roots
+// to w in Graphviz dot format.
+// It has a node for each package and an edge for each import,
+// except for packages in the standard library.
+// Root packages are drawn as boxes.
+func WriteGraphDot(w io.Writer, roots []*Package) error {
+	var pkgs []*Package
+	seen := make(map[string]bool)
+	for _, p := range roots {
+		for _, q := range append([]*Package{p}, p.deps...) {
+			if !seen[q.ImportPath] && !q.Standard {
+				seen[q.ImportPath] = true
+				pkgs = append(pkgs, q)
+			}
+		}
+	}
+	sort.Sort(byImportPath(pkgs))
+	isRootPath := make(map[string]bool)
+	for _, p := range roots {
+		isRootPath[p.ImportPath] = true
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph deps {")
+	for _, p := range pkgs {
+		if isRootPath[p.ImportPath] {
+			fmt.Fprintf(bw, "\t%q [shape=box, style=bold];\n", p.ImportPath)
+		} else {
+			fmt.Fprintf(bw, "\t%q;\n", p.ImportPath)
+		}
+	}
+	for _, p := range pkgs {
+		if p.Package == nil {
+			continue
+		}
+		imports := append([]string{}, p.Imports...)
+		sort.Strings(imports)
+		for _, imp := range imports {
+			if seen[imp] {
+				fmt.Fprintf(bw, "\t%q -> %q;\n", p.ImportPath, imp)
+			}
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreName is the name of the file, in the workspace,
+// from which vexp reads the files never to copy.
+const IgnoreName = ".vexpignore"
+
+// ReadIgnore reads patterns for Config.Ignores and
+// Config.PackageIgnores from the named file, one per line.
+// Patterns after a line of the form [pattern], naming
+// an import path pattern, such as [github.com/user/repo]
+// or [github.com/user/...], go in PackageIgnores, for the
+// packages it matches only, until the next such line.
+// Blank lines and lines starting with # are skipped.
+// It is not an error for the file not to exist.
+func ReadIgnore(name string) (pats []string, pkgPats map[string][]string, err error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, nil, &os.PathError{Op: "read", Path: name, Err: errors.New("empty import path in []")}
+			}
+			continue
+		}
+		if _, err := filepath.Match(filepath.FromSlash(line), ""); err != nil {
+			return nil, nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+		if section == "" {
+			pats = append(pats, line)
+			continue
+		}
+		if pkgPats == nil {
+			pkgPats = make(map[string][]string)
+		}
+		pkgPats[section] = append(pkgPats[section], line)
+	}
+	return pats, pkgPats, sc.Err()
+}
+
+// isIgnored reports whether rel, a path relative to the
+// directory of the package with import path importPath,
+// matches one of Config.Ignores, or one of the patterns
+// Config.PackageIgnores lists for that package.
+// Patterns ending in / match only directories.
+func (r *Resolver) isIgnored(importPath, rel string, isDir bool) bool {
+	if matchIgnore(r.Ignores, rel, isDir) {
+		return true
+	}
+	up, _ := r.unvendoredPath(importPath)
+	for pkgPat, pats := range r.PackageIgnores {
+		if matchPattern(pkgPat)(up) && matchIgnore(pats, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIgnore reports whether rel matches one of pats,
+// as for isIgnored.
+func matchIgnore(pats []string, rel string, isDir bool) bool {
+	for _, pat := range pats {
+		if strings.HasSuffix(pat, "/") {
+			if !isDir {
+				continue
+			}
+			pat = strings.TrimSuffix(pat, "/")
+		}
+		if ok, _ := filepath.Match(filepath.FromSlash(pat), rel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// licenseNames lists the names, without extension and
+// in upper case, of files that hold license terms.
+var licenseNames = []string{"LICENSE", "LICENCE", "COPYING", "NOTICE"}
+
+// isLicense reports whether name looks like
+// the name of a license file, such as LICENSE,
+// COPYING.txt, or License.md.
+func isLicense(name string) bool {
+	base := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+	for _, s := range licenseNames {
+		if base == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findLicenses returns the license files in the
+// nearest directory at or above pkg's directory that
+// has any, without leaving pkg's repository.
+// It returns nil if there are none.
+func findLicenses(pkg *Package) []string {
+	root := repoRoot(pkg.Dir, pkg.SrcRoot)
+	for dir := filepath.Clean(pkg.Dir); ; dir = filepath.Dir(dir) {
+		if a := licensesIn(dir); len(a) > 0 {
+			return a
+		}
+		if dir == root || !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return nil
+		}
+	}
+}
+
+// licensesIn returns the license files in dir.
+func licensesIn(dir string) []string {
+	fis, _ := ioutil.ReadDir(dir)
+	var a []string
+	for _, fi := range fis {
+		if !fi.IsDir() && isLicense(fi.Name()) {
+			a = append(a, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return a
+}
+
+// outsideLicenses returns the license files that apply to
+// pkg but that walkDep won't find, because they are in a
+// parent of pkg's directory. Under -licenses these are
+// copied into the package's vendored directory.
+func (r *Resolver) outsideLicenses(pkg *Package) []string {
+	if !r.Licenses || pkg.include {
+		return nil
+	}
+	a := findLicenses(pkg)
+	if len(a) > 0 && filepath.Dir(a[0]) == filepath.Clean(pkg.Dir) {
+		return nil
+	}
+	return a
+}
+
+// vcsDirs lists the metadata directories that
+// mark the root of a version control repository.
+var vcsDirs = []string{".git", ".hg", ".bzr", ".svn"}
+
+// repoRoot returns the root directory of the version
+// control repository containing dir, looking no higher
+// than the top level of srcRoot (a $GOPATH/src directory).
+// If there is no repository, it returns the directory
+// just below srcRoot that contains dir.
+func repoRoot(dir, srcRoot string) string {
+	if root := vcsRoot(dir, srcRoot); root != "" {
+		return root
+	}
+	dir = filepath.Clean(dir)
+	srcRoot = filepath.Clean(srcRoot)
+	top := dir
+	for d := dir; strings.HasPrefix(d, srcRoot+string(filepath.Separator)); d = filepath.Dir(d) {
+		top = d
+	}
+	return top
+}
+
+// vcsRoot is like repoRoot, but if there is
+// no repository, it returns "".
+func vcsRoot(dir, srcRoot string) string {
+	dir = filepath.Clean(dir)
+	srcRoot = filepath.Clean(srcRoot)
+	for d := dir; strings.HasPrefix(d, srcRoot+string(filepath.Separator)); d = filepath.Dir(d) {
+		for _, vcs := range vcsDirs {
+			if _, err := os.Stat(filepath.Join(d, vcs)); err == nil {
+				return d
+			}
+		}
+	}
+	return ""
+}
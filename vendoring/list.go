@@ -0,0 +1,34 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// WriteList writes the import paths of deps, which would be
+// copied, to w, one per line, for flag -list. If format is
+// not empty, it is instead a text/template, as for go list -f,
+// executed for each of deps, followed by a newline.
+func WriteList(w io.Writer, deps []*Package, format string) error {
+	if format == "" {
+		format = "{{.ImportPath}}"
+	}
+	tmpl, err := template.New("list").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid -list-format: %v", err)
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range deps {
+		if err := tmpl.Execute(bw, p); err != nil {
+			return err
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockName is the name of the lock file Lock creates
+// in the output directory.
+const LockName = ".vexp.lock"
+
+// lockPoll is how often Lock tries again
+// for a lock held by another run.
+var lockPoll = 100 * time.Millisecond
+
+// Lock creates LockName in the output directory, creating the
+// directory if need be, so that other runs of vexp calling
+// Lock don't change the vendor tree at the same time as this
+// one. If another run holds the lock, Lock tries again until
+// timeout has passed or ctx is done, then gives up and returns
+// an error saying so.
+// The unlock func it returns removes the lock file, and the
+// output directory too if Lock created it and it is empty.
+// It is safe to call more than once.
+func (r *Resolver) Lock(ctx context.Context, timeout time.Duration) (unlock func(), err error) {
+	root := filepath.Join(r.cwd, r.outDir)
+	name := filepath.Join(root, LockName)
+	deadline := time.Now().Add(timeout)
+	for {
+		// The run holding the lock may remove
+		// the directory when it's done.
+		_, statErr := os.Stat(root)
+		if err := r.makeOutDir(); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					os.Remove(name)
+					if os.IsNotExist(statErr) {
+						os.Remove(root) // only if empty
+					}
+				})
+			}, nil
+		}
+		if os.IsNotExist(err) {
+			continue // the directory went away; make it again
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%s exists: another vexp run is using %s; if not, remove it", r.shortPath(name), r.shortPath(root))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for %s: %v", r.shortPath(name), ctx.Err())
+		case <-time.After(lockPoll):
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ReadLockfile reads the named JSON file, an object mapping
+// import paths to source directories, as written by another
+// tool that resolves dependencies, and returns a Package for
+// each entry, to give to Copy in place of the dependencies
+// Resolve would find. Relative directories are taken to be
+// relative to the file's directory.
+// It is an error for a directory not to exist, to hold no
+// Go files, or to have an import comment naming another
+// path. The packages are sorted by import path.
+func (r *Resolver) ReadLockfile(name string) ([]*Package, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var dirs map[string]string
+	if err := json.Unmarshal(b, &dirs); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var pkgs []*Package
+	for path, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(name), dir)
+		}
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("%s: package %s: %v", name, path, err)
+		} else if !fi.IsDir() || !hasGoFiles(dir) {
+			return nil, fmt.Errorf("%s: package %s: no Go files in %s", name, path, dir)
+		}
+		bp, err := r.ctxt.ImportDir(dir, build.ImportComment)
+		if _, ok := err.(*build.NoGoError); ok {
+			err = nil
+		}
+		if err == nil {
+			err = checkImportComment(bp, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: package %s: %v", name, path, err)
+		}
+		bp.ImportPath = path
+		p := new(Package)
+		p.copyBuild(bp)
+		pkgs = append(pkgs, p)
+	}
+	sort.Sort(byImportPath(pkgs))
+	return pkgs, nil
+}
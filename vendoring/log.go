@@ -0,0 +1,109 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Verbosity levels, at which log and writeEvent print events.
+const (
+	levelError   = -1 // errors; always shown
+	levelWarn    = 0  // warnings; silenced by -q
+	levelVerbose = 1  // progress; shown by -v
+	levelSearch  = 2  // vendor directories searched; -v=2
+	levelFiles   = 3  // each file copied; -v=3
+)
+
+// levelNames names the verbosity levels in JSON logs.
+var levelNames = map[int]string{
+	levelError:   "error",
+	levelWarn:    "warning",
+	levelVerbose: "info",
+	levelSearch:  "debug",
+	levelFiles:   "debug",
+}
+
+// An event is a line of output. As text, it is Message alone,
+// after "warning: " for a warning; under -log-format=json, it
+// is the whole event, as a JSON object.
+type event struct {
+	Event      string // what happened: root, add, copy, warning, error, and so on
+	Level      string // if empty, set by writeEvent from its level
+	ImportPath string `json:",omitempty"`
+	Dir        string `json:",omitempty"` // the source directory or file
+	Dest       string `json:",omitempty"` // where it is copied in the vendor tree
+	Message    string
+}
+
+// checkLogFormat returns an error if f is not
+// a format Config.LogFormat may name.
+func checkLogFormat(f string) error {
+	if f != "" && f != "text" && f != "json" {
+		return fmt.Errorf("invalid -log-format %q: must be text or json", f)
+	}
+	return nil
+}
+
+// log prints e to w, as Config.LogFormat says,
+// if c.Verbosity is at least level.
+func (c *Config) log(w io.Writer, level int, e event) {
+	if c.Verbosity >= level {
+		c.writeEvent(w, level, e)
+	}
+}
+
+// writeEvent prints e to w, as Config.LogFormat says,
+// whatever c.Verbosity.
+func (c *Config) writeEvent(w io.Writer, level int, e event) {
+	if c.LogFormat == "json" {
+		if e.Level == "" {
+			e.Level = levelNames[level]
+		}
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(w, "%s\n", b)
+		return
+	}
+	if e.Event == "warning" {
+		fmt.Fprintf(w, "warning: %s\n", e.Message)
+		return
+	}
+	fmt.Fprintln(w, e.Message)
+}
+
+// warnf logs a warning about the package with the given
+// import path to w.
+func (c *Config) warnf(w io.Writer, importPath, format string, args ...interface{}) {
+	c.log(w, levelWarn, event{Event: "warning", ImportPath: importPath, Message: fmt.Sprintf(format, args...)})
+}
+
+// logError logs err, concerning the package with the
+// given import path, if any, to w.
+func (c *Config) logError(w io.Writer, importPath string, err error) {
+	c.log(w, levelError, event{Event: "error", ImportPath: importPath, Message: err.Error()})
+}
+
+// PrintWarning prints a warning, formatted from format and
+// args, to c.Stderr, as Config.LogFormat says, unless
+// c.Verbosity is below 0. The import path names the package
+// it concerns, if any.
+func (c *Config) PrintWarning(importPath, format string, args ...interface{}) {
+	c.warnf(c.stderr(), importPath, format, args...)
+}
+
+// PrintError prints err to c.Stderr, as Config.LogFormat
+// says. The import path names the package it concerns,
+// if any.
+func (c *Config) PrintError(importPath string, err error) {
+	c.logError(c.stderr(), importPath, err)
+}
+
+// PrintSummary prints s, the changes Copy made, to c.Stderr,
+// as Config.LogFormat says, unless c.Verbosity is below 0.
+func (c *Config) PrintSummary(s Summary) {
+	c.log(c.stderr(), levelWarn, event{Event: "summary", Level: "info", Message: "vexp: " + s.String()})
+}
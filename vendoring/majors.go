@@ -0,0 +1,79 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A MajorConflict is a package that the dependencies
+// include at more than one major version, such as
+// example.com/foo and example.com/foo/v2.
+type MajorConflict struct {
+	Base        string   // import path without its major version
+	ImportPaths []string // sorted
+}
+
+// MultipleMajors finds the packages among the dependencies
+// of the given packages, whether vendored already or not,
+// that are there at more than one major version, going by
+// a /vN element in their import paths, for N of 2 or more.
+// That doubles the code vendored, and often means some
+// dependency needs upgrading.
+func (r *Resolver) MultipleMajors(packages []*Package) []MajorConflict {
+	paths := make(map[string]map[string]bool)
+	for _, p := range packages {
+		for _, d := range p.deps {
+			if d.Dir == "" || d.Standard || r.isRoot(d) {
+				continue
+			}
+			path, _ := r.unvendoredPath(d.ImportPath)
+			base := majorBase(path)
+			if paths[base] == nil {
+				paths[base] = make(map[string]bool)
+			}
+			paths[base][path] = true
+		}
+	}
+	var a []MajorConflict
+	for base, set := range paths {
+		if len(set) < 2 {
+			continue
+		}
+		mc := MajorConflict{Base: base}
+		for path := range set {
+			mc.ImportPaths = append(mc.ImportPaths, path)
+		}
+		sort.Strings(mc.ImportPaths)
+		a = append(a, mc)
+	}
+	sort.Sort(byMajorBase(a))
+	return a
+}
+
+// majorBase returns path without its first /vN element,
+// for N of 2 or more, as in example.com/foo/v2/bar,
+// or path itself if there is none.
+func majorBase(path string) string {
+	elems := strings.Split(path, "/")
+	for i := 1; i < len(elems); i++ {
+		e := elems[i]
+		if len(e) < 2 || e[0] != 'v' || e[1] == '0' {
+			continue
+		}
+		if n, err := strconv.Atoi(e[1:]); err == nil && n >= 2 {
+			return strings.Join(append(elems[:i:i], elems[i+1:]...), "/")
+		}
+	}
+	return path
+}
+
+type byMajorBase []MajorConflict
+
+func (a byMajorBase) Len() int           { return len(a) }
+func (a byMajorBase) Less(i, j int) bool { return a[i].Base < a[j].Base }
+func (a byMajorBase) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,295 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestName is the name of the manifest file
+// written into the output directory by flag -manifest.
+const ManifestName = "vexp.json"
+
+// A Manifest records where each vendored package came from.
+type Manifest struct {
+	Platforms []string `json:",omitempty"` // targets considered, if not all
+	Packages  []ManifestPackage
+}
+
+// A ManifestPackage describes a single vendored package.
+type ManifestPackage struct {
+	ImportPath string // import path, as seen from outside the vendor tree
+	Dir        string // absolute source directory
+	Revision   string // VCS revision or module version of Dir, if known
+
+	// Files holds the SHA-256 checksum, in hex, of each file
+	// in the package's vendored copy, by slash-separated path
+	// relative to the package's directory in the vendor tree.
+	// Files of packages nested inside it are listed with those.
+	Files map[string]string `json:",omitempty"`
+}
+
+// WriteManifest writes a manifest describing the packages
+// in deps, which were copied, and vendored, which were
+// already present, to ManifestName in the output directory.
+// It records checksums of the files in the vendor tree,
+// so it should be called after Copy.
+func (r *Resolver) WriteManifest(deps, vendored []*Package) error {
+	m := new(Manifest)
+	for _, t := range r.Platforms {
+		m.Platforms = append(m.Platforms, t.String())
+	}
+	seen := make(map[string]bool)
+	for _, pkg := range deps {
+		if !seen[pkg.ImportPath] {
+			seen[pkg.ImportPath] = true
+			m.Packages = append(m.Packages, ManifestPackage{
+				ImportPath: pkg.ImportPath,
+				Dir:        pkg.Dir,
+				Revision:   r.revision(pkg.Dir, pkg.SrcRoot),
+			})
+		}
+	}
+	for _, pkg := range vendored {
+		path, _ := r.unvendoredPath(pkg.ImportPath)
+		if !seen[path] {
+			seen[path] = true
+			dir, srcRoot := r.sourceDir(path)
+			mp := ManifestPackage{ImportPath: path, Dir: dir}
+			if mp.Dir != "" {
+				mp.Revision = r.revision(mp.Dir, srcRoot)
+			}
+			m.Packages = append(m.Packages, mp)
+		}
+	}
+	sort.Sort(byManifestImportPath(m.Packages))
+	if err := r.addChecksums(m); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	dir := filepath.Join(r.cwd, r.outDir)
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ManifestName), b, 0666)
+}
+
+// sourceDir returns the directory outside the vendor tree
+// that holds the package with the given import path, along
+// with the root of the tree it is found in, as in SrcRoot,
+// or "" if there is no such directory.
+func (r *Resolver) sourceDir(path string) (dir, srcRoot string) {
+	bp, err := r.ctxt.Import(path, r.cwd, build.FindOnly|build.IgnoreVendor)
+	if err != nil {
+		if r.mainModule != nil {
+			dir, modDir := r.mainModule.find(path, r.modCache())
+			if dir != "" {
+				return dir, filepath.Dir(modDir)
+			}
+		}
+		return "", ""
+	}
+	return bp.Dir, bp.SrcRoot
+}
+
+// revision returns the git revision of dir, in a repository
+// within srcRoot, or, if dir is in the module cache, its
+// module version.
+func (r *Resolver) revision(dir, srcRoot string) string {
+	if rev := gitRevision(dir, srcRoot); rev != "" {
+		return rev
+	}
+	return r.modVersion(dir)
+}
+
+// addChecksums fills in the Files of each package in m
+// from the vendor tree.
+func (r *Resolver) addChecksums(m *Manifest) error {
+	files, err := r.vendorFiles()
+	if err != nil {
+		return err
+	}
+	pkgs := make(map[string]*ManifestPackage)
+	for i := range m.Packages {
+		pkgs[m.Packages[i].ImportPath] = &m.Packages[i]
+	}
+	for rel, file := range files {
+		mp, name := owner(pkgs, rel)
+		if mp == nil {
+			continue
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		if mp.Files == nil {
+			mp.Files = make(map[string]string)
+		}
+		mp.Files[name] = sum
+	}
+	return nil
+}
+
+// VerifyManifest checks the files in the vendor tree against
+// the checksums recorded in its manifest, without looking at
+// their sources. It prints a line to Config.Stderr for each
+// file that is missing, has changed, or is not listed, and
+// returns an error if there were any.
+// If ctx is done, VerifyManifest stops between files
+// and returns an error saying so.
+func (r *Resolver) VerifyManifest(ctx context.Context) error {
+	r.ctx = ctx
+	b, err := ioutil.ReadFile(filepath.Join(r.cwd, r.outDir, ManifestName))
+	if err != nil {
+		return err
+	}
+	m := new(Manifest)
+	if err = json.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("%s: %v", ManifestName, err)
+	}
+	files, err := r.vendorFiles()
+	if err != nil {
+		return err
+	}
+	pkgs := make(map[string]*ManifestPackage)
+	for i := range m.Packages {
+		pkgs[m.Packages[i].ImportPath] = &m.Packages[i]
+	}
+
+	var rels []string
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	for _, mp := range m.Packages {
+		for name := range mp.Files {
+			rel := path.Join(mp.ImportPath, name)
+			if files[rel] == "" {
+				rels = append(rels, rel)
+			}
+		}
+	}
+	sort.Strings(rels)
+
+	ok := true
+	for _, rel := range rels {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("verifying vendor tree: %v", err)
+		}
+		file := files[rel]
+		dst := filepath.Join(r.pkgRoot(), filepath.FromSlash(rel))
+		short := r.shortPath(dst)
+		mp, name := owner(pkgs, rel)
+		var want, path string
+		if mp != nil {
+			want, path = mp.Files[name], mp.ImportPath
+		}
+		bad := func(kind, msg string) {
+			e := event{Event: kind, ImportPath: path, Dest: dst, Message: short + ": " + msg}
+			r.log(r.stderr(), levelError, e)
+			ok = false
+		}
+		switch {
+		case want == "":
+			bad("extra", "not in manifest")
+		case file == "":
+			bad("missing", "missing")
+		default:
+			sum, err := fileSHA256(file)
+			if err != nil {
+				r.logError(r.stderr(), path, err)
+				ok = false
+			} else if sum != want {
+				bad("differs", "checksum mismatch")
+			}
+		}
+	}
+	if !ok {
+		return errors.New("vendor tree does not match manifest")
+	}
+	return nil
+}
+
+// vendorFiles returns the files in the vendor tree,
+// other than the manifest, as absolute paths keyed by
+// slash-separated path relative to pkgRoot.
+// Like verifyDeps, it skips what copyDep would never
+// have written.
+func (r *Resolver) vendorFiles() (map[string]string, error) {
+	root := r.pkgRoot()
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return nil
+			}
+			return err
+		}
+		_, elem := filepath.Split(p)
+		if p != root && r.skipped(elem) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		if !fi.IsDir() && rel != ManifestName {
+			files[filepath.ToSlash(rel)] = p
+		}
+		return nil
+	})
+	return files, err
+}
+
+// owner returns the package in pkgs, by import path, whose
+// vendored directory most closely encloses the file at the
+// slash-separated path rel in the vendor tree, and the file's
+// path relative to that directory. It returns nil if there
+// is no such package.
+func owner(pkgs map[string]*ManifestPackage, rel string) (*ManifestPackage, string) {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if mp := pkgs[dir]; mp != nil {
+			return mp, rel[len(dir)+1:]
+		}
+	}
+	return nil, ""
+}
+
+// fileSHA256 returns the SHA-256 checksum
+// of the named file, in hex.
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type byManifestImportPath []ManifestPackage
+
+func (a byManifestImportPath) Len() int           { return len(a) }
+func (a byManifestImportPath) Less(i, j int) bool { return a[i].ImportPath < a[j].ImportPath }
+func (a byManifestImportPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,71 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// ParseMap parses a comma-separated list of prefix=dir
+// entries, as given to flag -map, into a map for Config.Map.
+// Relative directories are made absolute.
+func ParseMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, "=")
+		if i < 1 || i == len(f)-1 {
+			return nil, fmt.Errorf("invalid -map entry %q: want prefix=dir", f)
+		}
+		dir, err := filepath.Abs(f[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimSuffix(f[:i], "/")] = dir
+	}
+	return m, nil
+}
+
+// mappedDir returns the directory to load the package with
+// import path path from, if path is under one of the prefixes
+// in Config.Map, using the longest that matches, along with
+// the directory that prefix maps to. Otherwise it returns "".
+func (r *Resolver) mappedDir(path string) (dir, root string) {
+	best := ""
+	for prefix := range r.Map {
+		if hasPathPrefix(path, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return "", ""
+	}
+	root = r.Map[best]
+	return filepath.Join(root, filepath.FromSlash(path[len(best):])), root
+}
+
+// snapshotDir returns the directory in Config.Snapshot
+// holding the package with import path path, if there is
+// one. It returns "" for packages in cwd, which are always
+// loaded from there, and for local import paths.
+func (r *Resolver) snapshotDir(path string) string {
+	if r.snapshot == "" || build.IsLocalImport(path) {
+		return ""
+	}
+	if r.cwdPath != "" && hasPathPrefix(path, r.cwdPath) {
+		return ""
+	}
+	dir := filepath.Join(r.snapshot, filepath.FromSlash(path))
+	if !r.isDir(dir) {
+		return ""
+	}
+	return dir
+}
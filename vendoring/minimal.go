@@ -0,0 +1,103 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"path/filepath"
+)
+
+// unused reports whether dir, a subdirectory of a package
+// being copied, holds a Go package that is not to be vendored.
+// If so, walkDep leaves out its files, and, unless it has a
+// subdirectory holding a package to vendor (as recorded by
+// listFiles), its whole tree. Without -prune, nothing is
+// unused.
+func (r *Resolver) unused(dir string) bool {
+	return r.depDirs != nil && !r.depDirs[dir] && hasGoFiles(dir)
+}
+
+// usedBelow reports whether any package to vendor
+// is in a subdirectory of dir.
+func (r *Resolver) usedBelow(dir string) bool {
+	for d := range r.depDirs {
+		if inDir(d, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// listFiles records, under -prune, the directories of pkgs,
+// the packages to vendor, so that walkDep can leave out the
+// packages in their subdirectories that are not among them;
+// see unused.
+// Under -minimal, it records the files to copy from those
+// directories: the source files go/build listed for each
+// package, and the whole of each cgo include directory a
+// package names inside its own directory. (Include directories
+// outside it are copied as pseudo-packages, in full; see
+// withIncludes.)
+func (r *Resolver) listFiles(pkgs []*Package) {
+	r.depDirs = nil
+	if r.Prune {
+		r.depDirs = make(map[string]bool)
+		for _, p := range pkgs {
+			if p.Package != nil && p.Dir != "" && !p.include {
+				r.depDirs[filepath.Clean(p.Dir)] = true
+			}
+		}
+	}
+	if !r.Minimal {
+		return
+	}
+	r.listedFiles = make(map[string]map[string]bool)
+	r.includeDirs = nil
+	for _, p := range pkgs {
+		if p.Package == nil || p.Dir == "" || p.include {
+			continue
+		}
+		dir := filepath.Clean(p.Dir)
+		set := r.listedFiles[dir]
+		if set == nil {
+			set = make(map[string]bool)
+			r.listedFiles[dir] = set
+		}
+		for _, name := range sourceFiles(p) {
+			set[name] = true
+		}
+		for _, inc := range cgoIncludeDirs(p) {
+			if inDir(inc, dir) {
+				r.includeDirs = append(r.includeDirs, inc)
+			}
+		}
+	}
+}
+
+// unlisted reports whether, under -minimal, the file or
+// directory at path is to be left out of the vendor tree:
+// a file that go/build did not list for the package in its
+// directory and that is not a license, or a directory that
+// holds none of the packages or include directories
+// recorded by listFiles.
+func (r *Resolver) unlisted(path string, isDir bool) bool {
+	if r.listedFiles == nil {
+		return false
+	}
+	for _, inc := range r.includeDirs {
+		if path == inc || inDir(path, inc) || isDir && inDir(inc, path) {
+			return false
+		}
+	}
+	if isDir {
+		for dir := range r.listedFiles {
+			if path == dir || inDir(dir, path) {
+				return false
+			}
+		}
+		return true
+	}
+	dir, name := filepath.Split(path)
+	return !isLicense(name) && !r.listedFiles[filepath.Clean(dir)][name]
+}
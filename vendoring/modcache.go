@@ -0,0 +1,234 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A goMod holds what vexp needs from a go.mod file.
+type goMod struct {
+	dir     string               // directory containing go.mod
+	path    string               // module path
+	require map[string]string    // module path -> version
+	replace map[string]modTarget // module path -> replacement
+}
+
+// A modTarget is the right-hand side of a replace directive.
+// If version is empty, path is a directory.
+type modTarget struct {
+	path, version string
+}
+
+// findGoMod returns the go.mod file in dir or
+// its nearest parent that has one, or "" if none does.
+func findGoMod(dir string) string {
+	for {
+		name := filepath.Join(dir, "go.mod")
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			return name
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readGoMod reads the module path, requirements,
+// and replacements from the go.mod file name.
+func readGoMod(name string) (*goMod, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &goMod{
+		dir:     filepath.Dir(name),
+		require: make(map[string]string),
+		replace: make(map[string]modTarget),
+	}
+	block := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && f[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(f) == 2 && f[1] == "(":
+			block = f[0]
+			continue
+		case block == "":
+			verb, f = f[0], f[1:]
+		}
+		for i := range f {
+			if s, err := strconv.Unquote(f[i]); err == nil {
+				f[i] = s
+			}
+		}
+		switch verb {
+		case "module":
+			if len(f) != 1 {
+				return nil, fmt.Errorf("%s:%d: malformed module directive", name, n)
+			}
+			m.path = f[0]
+		case "require":
+			if len(f) != 2 {
+				return nil, fmt.Errorf("%s:%d: malformed require directive", name, n)
+			}
+			m.require[f[0]] = f[1]
+		case "replace":
+			// old [version] => new [version]
+			i := 0
+			for i < len(f) && f[i] != "=>" {
+				i++
+			}
+			if i < 1 || i > 2 || len(f)-i < 2 || len(f)-i > 3 {
+				return nil, fmt.Errorf("%s:%d: malformed replace directive", name, n)
+			}
+			t := modTarget{path: f[i+1]}
+			if len(f)-i == 3 {
+				t.version = f[i+2]
+			}
+			m.replace[f[0]] = t
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if m.path == "" {
+		return nil, fmt.Errorf("%s: no module directive", name)
+	}
+	return m, nil
+}
+
+// importPath returns the import path of dir,
+// which must be in m's directory tree.
+func (m *goMod) importPath(dir string) string {
+	rel, err := filepath.Rel(m.dir, dir)
+	if err != nil || rel == "." {
+		return m.path
+	}
+	return pathpkg.Join(m.path, filepath.ToSlash(rel))
+}
+
+// contains reports whether dir is in m's directory tree.
+func (m *goMod) contains(dir string) bool {
+	dir = filepath.Clean(dir)
+	return dir == m.dir || inDir(dir, m.dir)
+}
+
+// find returns the directory holding the package with the given
+// import path, either in m itself or in the module cache, and
+// the root directory of the module it belongs to.
+// The module cache is in the directory cache.
+// It returns "", "" if no module m requires provides path.
+func (m *goMod) find(path, cache string) (dir, modDir string) {
+	if hasPathPrefix(path, m.path) {
+		return filepath.Join(m.dir, filepath.FromSlash(path[len(m.path):])), m.dir
+	}
+	best := ""
+	for mod := range m.require {
+		if hasPathPrefix(path, mod) && len(mod) > len(best) {
+			best = mod
+		}
+	}
+	if best == "" {
+		return "", ""
+	}
+	mod, version := best, m.require[best]
+	if t, ok := m.replace[mod]; ok {
+		if t.version == "" {
+			modDir = t.path
+			if !filepath.IsAbs(modDir) {
+				modDir = filepath.Join(m.dir, modDir)
+			}
+		}
+		mod, version = t.path, t.version
+	}
+	if modDir == "" {
+		modDir = filepath.Join(cache, filepath.FromSlash(escapeModPath(mod)+"@"+escapeModPath(version)))
+	}
+	dir = filepath.Join(modDir, filepath.FromSlash(path[len(best):]))
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", ""
+	}
+	return dir, modDir
+}
+
+// modCache returns the module cache directory.
+func (r *Resolver) modCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	list := filepath.SplitList(r.ctxt.GOPATH)
+	if len(list) == 0 {
+		return ""
+	}
+	return filepath.Join(list[0], "pkg", "mod")
+}
+
+// modVersion returns the version of the module holding dir,
+// if dir is in the module cache, or else "".
+func (r *Resolver) modVersion(dir string) string {
+	cache := r.modCache()
+	if cache == "" || !inDir(filepath.Clean(dir), filepath.Clean(cache)) {
+		return ""
+	}
+	rel, _ := filepath.Rel(cache, dir)
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		if i := strings.Index(elem, "@"); i >= 0 {
+			return unescapeModPath(elem[i+1:])
+		}
+	}
+	return ""
+}
+
+// escapeModPath encodes s the way the module cache does,
+// replacing each upper case letter with ! and its lower case.
+func escapeModPath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescapeModPath reverses escapeModPath.
+func unescapeModPath(s string) string {
+	var b strings.Builder
+	bang := false
+	for _, r := range s {
+		if bang {
+			r = unicode.ToUpper(r)
+		}
+		bang = r == '!'
+		if !bang {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Platform is a target operating system and architecture.
+type Platform struct {
+	GOOS, GOARCH string
+}
+
+func (p Platform) String() string {
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// ParsePlatforms parses a comma-separated list
+// of os/arch pairs, as given to flag -platforms.
+func ParsePlatforms(s string) ([]Platform, error) {
+	var a []Platform
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, "/")
+		if i < 1 || i == len(f)-1 || strings.Count(f, "/") != 1 {
+			return nil, fmt.Errorf("invalid platform %q: want os/arch", f)
+		}
+		a = append(a, Platform{f[:i], f[i+1:]})
+	}
+	return a, nil
+}
+
+// loadRoots is like packages, but when there are Config.Platforms,
+// it loads the packages once for each and merges the results.
+// The merged packages have the imports and dependencies found for
+// every target, each package appearing once, and selectedFiles
+// records which files those dependencies use.
+// Without Config.Platforms, but with a single target set in
+// Config.Context, as by -goos and -goarch, selectedFiles records
+// the files of that target.
+func (r *Resolver) loadRoots(args []string) []*Package {
+	if len(r.Platforms) == 0 {
+		roots := r.packages(args)
+		if !r.ctxt.UseAllFiles {
+			r.selectedFiles = make(map[string]map[string]bool)
+			r.selectAll(roots)
+		}
+		return roots
+	}
+	save := r.ctxt
+	defer func() { r.ctxt = save }()
+
+	var roots []*Package
+	byPath := make(map[string]*Package) // as first loaded
+	var loaded []*Package               // for every target
+	r.selectedFiles = make(map[string]map[string]bool)
+	for _, t := range r.Platforms {
+		r.ctxt = save
+		SetPlatform(&r.ctxt, t.GOOS, t.GOARCH)
+		r.packageCache = map[string]*Package{}
+		pkgs := r.packages(args)
+		r.selectAll(pkgs)
+		for _, p := range pkgs {
+			if byPath[p.ImportPath] == nil {
+				roots = append(roots, p)
+			}
+			for _, q := range append([]*Package{p}, p.deps...) {
+				if byPath[q.ImportPath] == nil {
+					byPath[q.ImportPath] = q
+				}
+				loaded = append(loaded, q)
+			}
+		}
+	}
+
+	// Each package is kept once, as first loaded, with the
+	// imports and dependencies it has for any target, so that
+	// the import graph leads to every dependency found.
+	imports := make(map[*Package][]*Package)
+	deps := make(map[*Package][]*Package)
+	for _, p := range loaded {
+		q := byPath[p.ImportPath]
+		imports[q] = mergePackages(imports[q], p.imports, byPath)
+		deps[q] = mergePackages(deps[q], p.deps, byPath)
+		if q.Error == nil {
+			q.Error = p.Error
+		}
+	}
+	for q := range imports {
+		q.imports = imports[q]
+		q.deps = deps[q]
+		sort.Sort(byImportPath(q.deps))
+	}
+	return roots
+}
+
+// mergePackages appends to a the packages in byPath with the
+// import paths of those in b, leaving out any already in a.
+func mergePackages(a, b []*Package, byPath map[string]*Package) []*Package {
+	for _, p := range b {
+		if q := byPath[p.ImportPath]; q != nil {
+			p = q
+		}
+		found := false
+		for _, q := range a {
+			found = found || q == p
+		}
+		if !found {
+			a = append(a, p)
+		}
+	}
+	return a
+}
+
+// selectAll calls selectFiles for the dependencies
+// of roots, and for roots outside cwd (from -add).
+func (r *Resolver) selectAll(roots []*Package) {
+	for _, p := range roots {
+		if !r.inCWD(p.Dir) {
+			r.selectFiles(p)
+		}
+		for _, d := range p.deps {
+			r.selectFiles(d)
+		}
+	}
+}
+
+// selectFiles adds the source files of p to r.selectedFiles,
+// which maps a package directory to the set of source files
+// go/build selected in it for any target.
+// If r.selectedFiles is nil, or has no entry for a directory,
+// all files in that directory are copied.
+func (r *Resolver) selectFiles(p *Package) {
+	if p.Package == nil || p.Dir == "" {
+		return
+	}
+	set := r.selectedFiles[p.Dir]
+	if set == nil {
+		set = make(map[string]bool)
+		r.selectedFiles[p.Dir] = set
+	}
+	for _, name := range sourceFiles(p) {
+		set[name] = true
+	}
+}
+
+// sourceFiles returns the names of all source files
+// go/build selected in p's directory.
+func sourceFiles(p *Package) []string {
+	return stringList(
+		p.GoFiles,
+		p.CgoFiles,
+		p.CFiles,
+		p.CXXFiles,
+		p.MFiles,
+		p.HFiles,
+		p.SFiles,
+		p.SysoFiles,
+		p.SwigFiles,
+		p.SwigCXXFiles,
+		p.TestGoFiles,
+		p.XTestGoFiles,
+	)
+}
+
+// sourceExts lists the file name extensions
+// of files that go/build selects by target,
+// which are the files the go tool builds.
+var sourceExts = map[string]bool{
+	".go":      true,
+	".c":       true,
+	".cc":      true,
+	".cpp":     true,
+	".cxx":     true,
+	".m":       true,
+	".h":       true,
+	".hh":      true,
+	".hpp":     true,
+	".hxx":     true,
+	".s":       true,
+	".S":       true,
+	".syso":    true,
+	".swig":    true,
+	".swigcxx": true,
+}
+
+// unselected reports whether the file at path is a source file
+// that go/build did not select for any target.
+func (r *Resolver) unselected(path string) bool {
+	dir, name := filepath.Split(path)
+	set, ok := r.selectedFiles[filepath.Clean(dir)]
+	return ok && sourceExts[filepath.Ext(name)] && !set[name]
+}
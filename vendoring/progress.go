@@ -0,0 +1,119 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressInterval is how often a progress line is printed
+// when it can't be rewritten in place; see progress.
+var progressInterval = time.Second
+
+// A progress counts the packages copied, for Config.Progress,
+// printing a line "copied N/M packages" to w as copyDeps reports
+// each in turn, along with the output of copying it.
+// It is not safe for use by more than one goroutine.
+// On a terminal, it rewrites a single line in place, unless
+// other progress reports go to the terminal too, as under
+// -v; otherwise, it prints a new line every progressInterval,
+// and once all M are done.
+type progress struct {
+	c       *Config
+	w       io.Writer
+	total   int
+	rewrite bool // whether to rewrite the line in place
+
+	n       int
+	last    time.Time // when a line was last printed
+	showing bool      // whether a line is on screen, under rewrite
+}
+
+// newProgress returns a progress for copying total packages,
+// or nil if c.Progress is not set. A nil *progress does nothing.
+func (c *Config) newProgress(total int) *progress {
+	if !c.Progress {
+		return nil
+	}
+	w := c.stderr()
+	return &progress{
+		c:       c,
+		w:       w,
+		total:   total,
+		last:    time.Now(),
+		rewrite: isTerminal(w) && c.LogFormat != "json" && c.Verbosity < levelVerbose && !c.DryRun,
+	}
+}
+
+// done counts another package copied.
+func (p *progress) done() {
+	if p == nil {
+		return
+	}
+	p.n++
+	if !p.rewrite && p.n < p.total && time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	if p.rewrite {
+		p.draw()
+		return
+	}
+	p.c.writeEvent(p.w, levelVerbose, event{Event: "progress", Message: p.message()})
+}
+
+// draw rewrites the line in place with the current count.
+func (p *progress) draw() {
+	fmt.Fprintf(p.w, "\r\033[K%s", p.message())
+	p.showing = true
+}
+
+// message returns the line to print.
+func (p *progress) message() string {
+	return fmt.Sprintf("copied %d/%d packages", p.n, p.total)
+}
+
+// clear takes the line being rewritten, if any, off the
+// screen, so that something else can be printed there.
+// The next call to done or finish puts it back.
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	if p.showing {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.showing = false
+	}
+}
+
+// finish ends the line being rewritten, if any,
+// leaving it on the screen with the final count.
+// Calls after the first do nothing.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	if p.rewrite && p.n > 0 {
+		if !p.showing {
+			p.draw()
+		}
+		fmt.Fprintln(p.w)
+		p.showing = false
+		p.rewrite = false
+	}
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// From sys/syscall.h and sys/fcntl.h.
+const (
+	sysClonefileat = 462
+	atFdcwd        = -2
+)
+
+// reflink makes dst, which must not exist, a copy-on-write
+// clone of src, with clonefile(2), as on APFS.
+// If the file system can't, it returns an error, leaving
+// dst as it was.
+func reflink(dst, src string) error {
+	s, err := syscall.BytePtrFromString(src)
+	if err != nil {
+		return err
+	}
+	d, err := syscall.BytePtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	fdcwd := atFdcwd
+	_, _, errno := syscall.Syscall6(sysClonefileat, uintptr(fdcwd), uintptr(unsafe.Pointer(s)), uintptr(fdcwd), uintptr(unsafe.Pointer(d)), 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "reflink", Path: dst, Err: errno}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request, from linux/fs.h.
+const ficlone = 0x40049409
+
+// reflink makes dst, which must not exist, a copy-on-write
+// clone of src, with the FICLONE ioctl, as on Btrfs and XFS.
+// If the file system can't, it returns an error, leaving
+// dst as it was.
+func reflink(dst, src string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, df.Fd(), ficlone, sf.Fd())
+	err = df.Close()
+	if errno != 0 {
+		err = &os.PathError{Op: "reflink", Path: dst, Err: errno}
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin
+
+package vendoring
+
+import "errors"
+
+// reflink would make dst a copy-on-write clone of src,
+// but vexp doesn't know how to on this system.
+func reflink(dst, src string) error {
+	return errors.New("reflink: not supported on this system")
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// licenseIDs lists SPDX identifiers of common licenses,
+// each with phrases that, all appearing near the top of
+// a license file, identify it. The first match wins, so
+// more specific entries come first: the text of each
+// LGPL mentions the GNU General Public License, and
+// that of LGPL-2.1, "version 2" too. A phrase ending in
+// a number must not be followed by more of it, so that
+// "version 2" doesn't match "version 2.1".
+var licenseIDs = []struct {
+	id      string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"LGPL-2.0", []string{"gnu library general public license", "version 2"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+}
+
+// licenseID returns the SPDX identifier of the license
+// in the named file, as best it can tell from the first
+// few kilobytes, or "" if it doesn't recognize it.
+func licenseID(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(f, 4<<10))
+	text := strings.ToLower(strings.Join(strings.Fields(string(b)), " "))
+	for _, l := range licenseIDs {
+		all := true
+		for _, p := range l.phrases {
+			all = all && containsPhrase(text, p)
+		}
+		if all {
+			return l.id
+		}
+	}
+	return ""
+}
+
+// containsPhrase reports whether text contains phrase,
+// other than where a number ending phrase goes on, as
+// "2" does in "2.1" or "20".
+func containsPhrase(text, phrase string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], phrase)
+		if j < 0 {
+			return false
+		}
+		end := i + j + len(phrase)
+		rest := text[end:]
+		if !isDigit(phrase[len(phrase)-1]) || !continuesNumber(rest) {
+			return true
+		}
+		i = i + j + 1
+	}
+}
+
+// continuesNumber reports whether s, following a digit,
+// continues the number, with another digit or with a
+// point and a digit.
+func continuesNumber(s string) bool {
+	if len(s) > 0 && isDigit(s[0]) {
+		return true
+	}
+	return len(s) > 1 && s[0] == '.' && isDigit(s[1])
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// A reportLine is a row of the table written by WriteReport.
+type reportLine struct {
+	importPath, version, license string
+}
+
+// WriteReport writes to w a table of the packages in deps,
+// which would be copied, and vendored, which are already
+// present, sorted by import path. It gives each one's
+// version, as recorded in the manifest, and its license:
+// the SPDX identifier, if recognized, and the name of the
+// license file found, as by -licenses. Licenses of vendored
+// packages are looked for only inside the vendor tree.
+func (r *Resolver) WriteReport(w io.Writer, deps, vendored []*Package) error {
+	var lines []reportLine
+	seen := make(map[string]bool)
+	add := func(pkg *Package, path string, files []string) {
+		if seen[path] || pkg.Package == nil || pkg.include {
+			return
+		}
+		seen[path] = true
+		rev := r.revision(pkg.Dir, pkg.SrcRoot)
+		if len(rev) == 40 {
+			rev = rev[:12] // a git commit
+		}
+		lines = append(lines, reportLine{path, rev, describeLicenses(files)})
+	}
+	for _, pkg := range deps {
+		add(pkg, pkg.ImportPath, findLicenses(pkg))
+	}
+	root := filepath.Join(r.cwd, r.outDir)
+	for _, pkg := range vendored {
+		path, _ := r.unvendoredPath(pkg.ImportPath)
+		var files []string
+		for dir := filepath.Clean(pkg.Dir); inDir(dir, root) && len(files) == 0; dir = filepath.Dir(dir) {
+			files = licensesIn(dir)
+		}
+		add(pkg, path, files)
+	}
+	sort.Sort(byReportPath(lines))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMPORT PATH\tVERSION\tLICENSE")
+	for _, l := range lines {
+		if l.version == "" {
+			l.version = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", l.importPath, l.version, l.license)
+	}
+	return tw.Flush()
+}
+
+// describeLicenses returns a description of the license
+// files for WriteReport, such as "MIT (LICENSE)",
+// "unknown (COPYING)", or "none".
+func describeLicenses(files []string) string {
+	if len(files) == 0 {
+		return "none"
+	}
+	var a []string
+	for _, f := range files {
+		id := licenseID(f)
+		if id == "" {
+			id = "unknown"
+		}
+		a = append(a, fmt.Sprintf("%s (%s)", id, filepath.Base(f)))
+	}
+	return strings.Join(a, ", ")
+}
+
+type byReportPath []reportLine
+
+func (a byReportPath) Len() int           { return len(a) }
+func (a byReportPath) Less(i, j int) bool { return a[i].importPath < a[j].importPath }
+func (a byReportPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
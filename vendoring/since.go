@@ -0,0 +1,76 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// changedSince reports whether, under -since, the package
+// with import path path has a source file outside the vendor
+// tree modified after Config.Since, so that its vendored copy,
+// if any, should be replaced, as if by -u.
+func (r *Resolver) changedSince(path string) bool {
+	if r.Since.IsZero() {
+		return false
+	}
+	if changed, ok := r.changedCache[path]; ok {
+		return changed
+	}
+	changed := false
+	bp, err := r.ctxt.Import(path, r.cwd, build.FindOnly|build.IgnoreVendor)
+	if err == nil {
+		changed = dirModTime(bp.Dir).After(r.Since)
+	}
+	r.changedCache[path] = changed
+	return changed
+}
+
+// dirModTime returns the newest modification time
+// of the files directly in dir.
+func dirModTime(dir string) time.Time {
+	var t time.Time
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		if !fi.IsDir() && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// NewestModTime returns the newest modification time of any
+// file in the tree packages are copied into, as by pkgRoot,
+// or the zero Time if there are none. Vendored files keep the
+// modification times of their sources, so this is a time after
+// which any changed source must have been changed, as used
+// by -since=last.
+func (r *Resolver) NewestModTime() (time.Time, error) {
+	return newestModTime(r.pkgRoot())
+}
+
+// newestModTime returns the newest modification time of
+// any file in the tree rooted at dir, or the zero Time if
+// there are none.
+func newestModTime(dir string) (time.Time, error) {
+	var t time.Time
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+		return nil
+	})
+	return t, err
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stripCategories maps each category of file
+// Config.Strip may name to a predicate on file names.
+var stripCategories = map[string]func(name string) bool{
+	"examples": func(name string) bool {
+		return strings.HasPrefix(name, "example") && strings.HasSuffix(name, "_test.go")
+	},
+	"tests": func(name string) bool {
+		return strings.HasSuffix(name, "_test.go")
+	},
+	"docs": func(name string) bool {
+		return name == "doc.go"
+	},
+}
+
+// checkStrip returns an error if any of cats
+// is not one of stripCategories.
+func checkStrip(cats []string) error {
+	for _, cat := range cats {
+		if stripCategories[cat] == nil {
+			return fmt.Errorf("unknown -strip category %q: must be examples, tests, or docs", cat)
+		}
+	}
+	return nil
+}
+
+// isStripped reports whether the file with the given name
+// is in one of the categories in Config.Strip.
+func (r *Resolver) isStripped(name string) bool {
+	for _, cat := range r.Strip {
+		if stripCategories[cat](name) {
+			return true
+		}
+	}
+	return false
+}
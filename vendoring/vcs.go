@@ -0,0 +1,109 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitRevision returns the commit hash checked out
+// in the git repository containing dir, within srcRoot,
+// or "" if dir is not in such a git repository or the
+// revision can't be determined.
+// It reads the repository metadata directly
+// rather than running git.
+func gitRevision(dir, srcRoot string) string {
+	gitDir := findGitDir(dir, srcRoot)
+	if gitDir == "" {
+		return ""
+	}
+	head, err := readLine(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	if !strings.HasPrefix(head, "ref: ") {
+		return head // detached HEAD
+	}
+	ref := strings.TrimSpace(head[len("ref: "):])
+	if rev, err := readLine(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return rev
+	}
+	return packedRef(gitDir, ref)
+}
+
+// findGitDir looks for a .git directory in dir
+// and each of its parents in turn, stopping short
+// of srcRoot, unless it is "".
+// That way a package copied by hand into a GOPATH
+// inside some other checkout isn't taken for part
+// of that repository.
+// It returns the path of the first one found,
+// or "" if there is none.
+// A .git file of the form "gitdir: path",
+// as used by submodules and worktrees,
+// is followed to the directory it names.
+func findGitDir(dir, srcRoot string) string {
+	dir = filepath.Clean(dir)
+	srcRoot = filepath.Clean(srcRoot)
+	for {
+		if srcRoot != "." && !strings.HasPrefix(dir, srcRoot+string(filepath.Separator)) {
+			return ""
+		}
+		p := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(p); err == nil {
+			if fi.IsDir() {
+				return p
+			}
+			line, err := readLine(p)
+			if err == nil && strings.HasPrefix(line, "gitdir: ") {
+				gd := strings.TrimSpace(line[len("gitdir: "):])
+				if !filepath.IsAbs(gd) {
+					gd = filepath.Join(dir, gd)
+				}
+				return gd
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// packedRef looks up ref in gitDir's packed-refs file.
+func packedRef(gitDir, ref string) string {
+	f, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// readLine returns the first line of the named file,
+// with surrounding space removed.
+func readLine(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
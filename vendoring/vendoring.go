@@ -0,0 +1,2396 @@
+// Copyright 2015 Keith Rarick.
+// Portions copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vendoring finds the dependencies of Go packages
+// and copies them into a vendor tree, as the vexp command does.
+//
+// A Config says where to work and what to copy.
+// A Resolver carries it out: its Resolve method loads the
+// packages and their dependencies, and its Copy method
+// copies those dependencies into place. Both take a
+// context.Context, which can cancel a long run:
+//
+//	r, err := vendoring.NewResolver(vendoring.New(dir))
+//	...
+//	roots, deps, err := r.Resolve(ctx, []string{"./..."})
+//	...
+//	err = r.Copy(ctx, deps)
+package vendoring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Config describes a vendoring job: the workspace whose
+// packages to vendor, how to find their dependencies,
+// and how to copy them.
+// Each field corresponds to a flag of the vexp command.
+type Config struct {
+	Dir     string        // the workspace: vendor the dependencies of packages in this directory
+	Context build.Context // for finding packages; see DefaultContext
+	GOBIN   string        // if not empty, the BinDir of every package loaded
+	OutDir  string        // directory, relative to Dir, that holds vendored packages; "vendor" if empty
+
+	Update  []string  // patterns of packages to copy again even if already vendored, or "all"; "!pattern" to exempt some (-u)
+	Since   time.Time // if not zero, also copy again packages with source files modified after this (-since)
+	Exclude []string  // patterns of packages neither to vendor nor to follow (-exclude)
+	Add     []string  // import paths to vendor even if nothing imports them (-add)
+	Allow   []string  // if not empty, prefixes or patterns of the only import paths that may be vendored (-allow)
+	Goroot  []string  // prefixes or patterns of packages in GOROOT to vendor, rather than treat as standard (-vendor-goroot)
+
+	// Map maps import path prefixes to directories from which to
+	// load the packages under them, instead of GOPATH; see ParseMap (-map).
+	Map map[string]string
+
+	// Snapshot, if not empty, is a directory holding the source
+	// of packages under their import paths, as in GOPATH's src
+	// directory, from which to load dependencies before looking
+	// in GOPATH (-snapshot).
+	Snapshot string
+
+	// IgnoreVendorErrors makes Resolve load a package from GOPATH
+	// when its copy in the vendor tree has an error, so that a
+	// broken copy is replaced (-ignore-vendor-errors).
+	IgnoreVendorErrors bool
+
+	NoTest       bool       // leave out _test.go files (-notest)
+	Strip        []string   // categories of files to leave out: examples, tests, or docs (-strip)
+	TestDeps     string     // "roots" to follow test imports of root packages only (-testdeps)
+	Platforms    []Platform // resolve once for each of these, and copy only their files (-platforms)
+	Jobs         int        // copy this many packages at once (-j)
+	Link         bool       // hard link files instead of copying them, where possible (-link)
+	Reflink      bool       // clone files, copy-on-write, instead of copying them, where possible (-reflink)
+	Fsync        bool       // flush each file copied, and the directories holding them, to stable storage (-fsync)
+	Progress     bool       // print a line counting the packages copied as they finish (-progress)
+	Licenses     bool       // copy license files from parent directories (-licenses)
+	GoFilesOnly  bool       // copy only source and license files (-gofilesonly)
+	Flatten      bool       // leave out dependencies' own vendor directories (-flatten)
+	Underscore   bool       // copy files and directories whose names start with _ (-copy-underscore)
+	GopathLayout bool       // copy packages into the src subdirectory of OutDir, as in a GOPATH entry (-gopath-layout)
+	Nested       bool       // resolve dependencies' imports from their own vendor directories first (-nested)
+	DryRun       bool       // report what would be copied, without copying (-n)
+	Stats        bool       // print the files and bytes copied per package (-stats)
+	ModCache     bool       // look in the module cache for packages missing from GOPATH (-modcache)
+	NormalizeEOL bool       // rewrite CRLF line endings in source files to LF (-normalize-eol)
+	Minimal      bool       // copy only the files go/build lists, and licenses (-minimal)
+	Prune        bool       // leave out subpackages of dependencies that are not dependencies themselves (-prune)
+	RequireVCS   bool       // treat a dependency outside any version control repository as an error (-require-vcs)
+	CopyGoMod    bool       // copy the go.mod and go.sum of each package's module into its directory (-copy-gomod)
+	Exec         string     // shell command to run for each package copied; see runHooks (-exec)
+	MaxFileSize  int64      // if positive, leave out files other than .go files larger than this many bytes (-max-file-size)
+	Ignores      []string   // glob patterns of files never to copy; see ReadIgnore
+
+	// PackageIgnores maps import path patterns to glob patterns
+	// of files not to copy from the packages they match, besides
+	// those in Ignores; see ReadIgnore.
+	PackageIgnores map[string][]string
+
+	// Verbosity is how much to print besides errors:
+	// -1 for nothing else, 0 for warnings, and 1 to 3
+	// for more and more progress reports (-q and -v).
+	Verbosity int
+
+	// LogFormat is how to print errors, warnings, and progress
+	// reports: "text", the default if empty, or "json", for one
+	// JSON object per line (-log-format).
+	LogFormat string
+
+	Stdout io.Writer // for reports; os.Stdout if nil
+	Stderr io.Writer // for errors and warnings; os.Stderr if nil
+}
+
+// New returns a Config for vendoring the dependencies of
+// the packages in dir, with the same defaults as vexp.
+func New(dir string) *Config {
+	return &Config{
+		Dir:      dir,
+		Context:  DefaultContext(),
+		GOBIN:    os.Getenv("GOBIN"),
+		OutDir:   "vendor",
+		TestDeps: "all",
+		Jobs:     runtime.GOMAXPROCS(0),
+	}
+}
+
+// DefaultContext returns the build context vexp uses by default.
+// It is build.Default, but with UseAllFiles set, so that
+// the imports of files for every target system are followed.
+func DefaultContext() build.Context {
+	c := build.Default
+	c.UseAllFiles = true
+	return c
+}
+
+// A Resolver finds and copies packages as its Config says.
+// It caches what it learns of the file system along the way,
+// so it is meant for a single run: to start afresh, make a
+// new Resolver. The Config must not change while its
+// Resolver is in use.
+//
+// A Resolver must not be used by more than one goroutine at once,
+// but different Resolvers, even for the same Config, are independent.
+type Resolver struct {
+	*Config
+
+	cwd    string          // Config.Dir
+	gobin  string          // Config.GOBIN
+	ctxt   build.Context   // Config.Context, with the platform set while loading for each of Config.Platforms
+	outDir string          // Config.OutDir, cleaned
+	ctx    context.Context // of the Resolve, Copy, or Verify call in progress
+
+	skipVendor    []func(string) bool        // import paths not to search for in vendor directories
+	updateNegated []bool                     // whether each of skipVendor is negated, with "!"; see updating
+	updateUsed    []bool                     // whether each of skipVendor matched an import path
+	excluded      []func(string) bool        // import paths not to load or vendor at all
+	allowed       []func(string) bool        // import paths that may be vendored, if any are given
+	vendorGoroot  []func(string) bool        // import paths in GOROOT not to treat as standard
+	mainModule    *goMod                     // the module containing cwd, under ModCache
+	snapshot      string                     // Config.Snapshot, made absolute
+	cwdPath       string                     // import path of cwd, if known; see snapshotDir
+	packageCache  map[string]*Package        // by import path, so that loading a package twice gives the same pointer
+	isDirCache    map[string]bool            // results of isDir
+	realPathCache map[string]string          // results of realPath
+	changedCache  map[string]bool            // results of changedSince
+	selectedFiles map[string]map[string]bool // see selectFiles
+	listedFiles   map[string]map[string]bool // see listFiles
+	depDirs       map[string]bool            // see listFiles
+	embedded      map[string]bool            // see listEmbeds
+	embedDirs     map[string]bool            // see listEmbeds
+	goMods        map[string]string          // see listGoMods
+	includeDirs   []string                   // see listFiles
+	summary       Summary                    // of the last Copy
+	copied        []*Package                 // copyList of the last copyDeps, for runHooks
+	unmatched     []string                   // patterns given to Resolve that matched no packages
+	cleaned       bool                       // whether Clean has emptied the output directory, or would have, under DryRun
+
+	writtenMu sync.Mutex
+	written   map[string]string // destination paths of this copy, by toFold; see claimPath
+}
+
+// NewResolver returns a Resolver for c.
+// It returns an error if c is invalid.
+func NewResolver(c *Config) (*Resolver, error) {
+	r := &Resolver{
+		Config:        c,
+		cwd:           c.Dir,
+		gobin:         c.GOBIN,
+		ctxt:          c.Context,
+		ctx:           context.Background(),
+		skipVendor:    matchers(trimNegations(c.Update)),
+		updateNegated: negations(c.Update),
+		updateUsed:    make([]bool, len(c.Update)),
+		excluded:      matchers(c.Exclude),
+		packageCache:  make(map[string]*Package),
+		isDirCache:    make(map[string]bool),
+		realPathCache: make(map[string]string),
+		changedCache:  make(map[string]bool),
+		allowed:       prefixMatchers(c.Allow),
+		vendorGoroot:  prefixMatchers(c.Goroot),
+	}
+	if err := checkStrip(c.Strip); err != nil {
+		return nil, err
+	}
+	if err := checkLogFormat(c.LogFormat); err != nil {
+		return nil, err
+	}
+	o := c.OutDir
+	if o == "" {
+		o = "vendor"
+	}
+	var err error
+	r.outDir, err = r.cleanOutDir(o)
+	if err != nil {
+		return nil, err
+	}
+	if c.Snapshot != "" {
+		r.snapshot, err = filepath.Abs(c.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := os.Stat(r.snapshot); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("snapshot %s is not a directory", c.Snapshot)
+		}
+	}
+	if c.ModCache {
+		name := findGoMod(r.cwd)
+		if name == "" {
+			return nil, fmt.Errorf("no go.mod in %s or any parent", r.cwd)
+		}
+		r.mainModule, err = readGoMod(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.snapshot != "" {
+		r.cwdPath = r.dirImportPath(r.cwd)
+	}
+	return r, nil
+}
+
+// Resolve loads the packages matched by patterns,
+// which are as given on the vexp command line,
+// along with those named by Config.Add, and finds
+// their dependencies.
+// It returns the packages loaded, called the roots,
+// and the dependencies to vendor, sorted by import path.
+// These exclude packages in Config.Dir, other than vendored
+// ones, and packages in the standard library.
+//
+// Resolve returns an error if patterns name packages
+// outside Config.Dir, or if ctx is done before it finishes.
+// An error loading a particular package is recorded in its
+// Error field instead.
+func (r *Resolver) Resolve(ctx context.Context, patterns []string) (roots, deps []*Package, err error) {
+	r.ctx = ctx
+	args, err := r.rootArgs(patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append(args, r.Add...)
+	roots = r.loadRoots(args)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("resolving dependencies: %v", err)
+	}
+	return roots, r.dependencies(roots), nil
+}
+
+// Copy copies deps, as returned by Resolve,
+// into the vendor tree.
+// It prints any errors to Config.Stderr as it goes,
+// and returns an error if there were any.
+// If ctx is done, Copy stops between files and returns
+// an error saying so; packages it had not finished copying
+// are left as they were.
+// If the vendor directory is not a directory, or can't be
+// created, Copy returns an error without copying anything.
+func (r *Resolver) Copy(ctx context.Context, deps []*Package) error {
+	r.ctx = ctx
+	if len(deps) > 0 && !r.DryRun {
+		if err := r.makeOutDir(); err != nil {
+			return err
+		}
+	}
+	ok := r.copyDeps(deps)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("copying dependencies: %v", err)
+	}
+	if !ok {
+		return errors.New("error(s) copying dependencies")
+	}
+	if r.Exec != "" && !r.DryRun {
+		return r.runHooks(r.copied)
+	}
+	return nil
+}
+
+// Verify compares deps, as returned by Resolve, with their
+// copies in the vendor tree, without copying anything.
+// It prints a line to Config.Stderr for each file that is
+// out of date, and returns an error if there were any.
+// For a useful comparison, Config.Update should match every
+// package, so that Resolve finds the sources outside the
+// vendor tree. If ctx is done, Verify stops between files
+// and returns an error saying so.
+func (r *Resolver) Verify(ctx context.Context, deps []*Package) error {
+	r.ctx = ctx
+	ok := r.verifyDeps(deps)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("verifying vendor tree: %v", err)
+	}
+	if !ok {
+		return errors.New("vendor tree is out of date")
+	}
+	return nil
+}
+
+func (c *Config) stdout() io.Writer {
+	if c.Stdout != nil {
+		return c.Stdout
+	}
+	return os.Stdout
+}
+
+func (c *Config) stderr() io.Writer {
+	if c.Stderr != nil {
+		return c.Stderr
+	}
+	return os.Stderr
+}
+
+// rootArgs expands the package patterns on the command line
+// into a list of local import paths for loadRoots.
+// Patterns may be relative paths, like ./cmd/..., or import
+// paths, like example.com/repo/cmd/..., but either way they
+// must name packages inside cwd.
+func (r *Resolver) rootArgs(patterns []string) ([]string, error) {
+	var args []string
+	for _, pat := range patterns {
+		local := pat
+		if !build.IsLocalImport(pat) {
+			ip := r.dirImportPath(r.cwd)
+			if ip == "" || !hasPathPrefix(pat, ip) {
+				return nil, fmt.Errorf("pattern %s is outside %s", pat, r.cwd)
+			}
+			local = "." + pat[len(ip):]
+		}
+		dir := local
+		if i := strings.Index(dir, "..."); i >= 0 {
+			dir, _ = pathpkg.Split(dir[:i])
+		}
+		if !r.inCWD(filepath.Join(r.cwd, filepath.FromSlash(dir))) {
+			return nil, fmt.Errorf("pattern %s is outside %s", pat, r.cwd)
+		}
+		if strings.Contains(local, "...") {
+			matched := r.matchPackagesInFS(local)
+			if len(matched) == 0 {
+				r.unmatched = append(r.unmatched, pat)
+			}
+			args = append(args, matched...)
+		} else {
+			args = append(args, local)
+		}
+	}
+	return args, nil
+}
+
+// dirImportPath returns the import path of the package
+// in dir, found from its location in $GOPATH or, under
+// flag -modcache, in the main module. It returns ""
+// if dir is in neither.
+func (r *Resolver) dirImportPath(dir string) string {
+	bp, _ := r.ctxt.ImportDir(dir, build.FindOnly)
+	if bp.ImportPath != "" && bp.ImportPath != "." {
+		return bp.ImportPath
+	}
+	if r.mainModule != nil && r.mainModule.contains(dir) {
+		return r.mainModule.importPath(dir)
+	}
+	return ""
+}
+
+// matchers returns a matchPattern func for each pattern in pats.
+// Pattern all is short for ..., matching every import path,
+// so that -u=all updates every dependency.
+func matchers(pats []string) (a []func(string) bool) {
+	for _, pat := range pats {
+		if pat == "all" {
+			pat = "..."
+		}
+		a = append(a, matchPattern(pat))
+	}
+	return
+}
+
+// trimNegations returns pats with
+// any leading "!" removed from each.
+func trimNegations(pats []string) (a []string) {
+	for _, pat := range pats {
+		a = append(a, strings.TrimPrefix(pat, "!"))
+	}
+	return a
+}
+
+// negations reports, for each of pats,
+// whether it is negated with a leading "!".
+func negations(pats []string) (a []bool) {
+	for _, pat := range pats {
+		a = append(a, strings.HasPrefix(pat, "!"))
+	}
+	return a
+}
+
+// updating reports whether the package with the given
+// import path is to be copied again, per Config.Update:
+// whether it matches one of the patterns, and none of the
+// negated ones, written with a leading "!", which win over
+// the others whatever their order. It records in updateUsed
+// which patterns matched.
+func (r *Resolver) updating(path string) bool {
+	update, keep := false, false
+	for i, match := range r.skipVendor {
+		if match(path) {
+			r.updateUsed[i] = true
+			if r.updateNegated[i] {
+				keep = true
+			} else {
+				update = true
+			}
+		}
+	}
+	return update && !keep
+}
+
+// prefixMatchers is like matchers, but a pattern
+// without ... matches that import path and the
+// paths under it, as a prefix.
+func prefixMatchers(pats []string) (a []func(string) bool) {
+	for _, pat := range pats {
+		if !strings.Contains(pat, "...") {
+			pat += "/..."
+		}
+		a = append(a, matchPattern(pat))
+	}
+	return
+}
+
+// cleanOutDir returns o cleaned and made relative to cwd.
+// It is an error for o to be outside cwd.
+func (r *Resolver) cleanOutDir(o string) (string, error) {
+	if filepath.IsAbs(o) {
+		rel, err := filepath.Rel(r.cwd, o)
+		if err != nil {
+			return "", err
+		}
+		o = rel
+	}
+	o = filepath.Clean(o)
+	if o == "." || o == ".." || strings.HasPrefix(o, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output directory %s is not inside %s", o, r.cwd)
+	}
+	return o, nil
+}
+
+// makeOutDir creates the vendor directory if need be.
+// It returns an error if something other than a directory
+// is in the way, saying what to do about it.
+func (r *Resolver) makeOutDir() error {
+	root := filepath.Join(r.cwd, r.outDir)
+	fi, err := os.Stat(root)
+	if err == nil && !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory; remove it, or choose another with -o", r.shortPath(root))
+	}
+	if err != nil {
+		if err = os.MkdirAll(root, 0777); err != nil {
+			return fmt.Errorf("can't create vendor directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// dependencies returns the list of dependencies
+// of the given packages,
+// excluding any from cwd or the standard library.
+// Packages given with -add that are outside cwd
+// are included too.
+func (r *Resolver) dependencies(packages []*Package) (deps []*Package) {
+	for _, p := range packages {
+		r.log(r.stdout(), levelVerbose, event{Event: "root", ImportPath: p.ImportPath, Dir: p.Dir, Message: "root " + p.ImportPath})
+		if p.Dir != "" && !p.Standard && !r.inCWD(p.Dir) {
+			deps = append(deps, p)
+		}
+		for _, d := range p.deps {
+			if r.inCWD(d.Dir) {
+				continue
+			}
+			deps = append(deps, d)
+		}
+	}
+	sort.Sort(byImportPath(deps))
+	return deps
+}
+
+// UnmatchedUpdates returns the patterns in Config.Update
+// that matched no import path found by Resolve, such as
+// misspelled ones.
+func (r *Resolver) UnmatchedUpdates() []string {
+	var a []string
+	for i, used := range r.updateUsed {
+		if !used {
+			a = append(a, r.Update[i])
+		}
+	}
+	return a
+}
+
+// unversioned reports whether p, a dependency, is in no
+// version control repository within its $GOPATH/src tree.
+// Packages in the module cache count as versioned, as do
+// those in GOROOT, vendored under -vendor-goroot.
+func (r *Resolver) unversioned(p *Package) bool {
+	return !p.Goroot && vcsRoot(p.Dir, p.SrcRoot) == "" && r.modVersion(p.Dir) == ""
+}
+
+// UnmatchedPatterns returns the patterns given to Resolve
+// that matched no packages, such as ./cmd/... in a directory
+// with no commands. A pattern without ... names a single
+// package, and an error loading it is reported on the
+// package instead.
+func (r *Resolver) UnmatchedPatterns() []string {
+	return r.unmatched
+}
+
+// Loaded returns the packages in pkgs that loaded
+// without error and are not in the standard library.
+func Loaded(pkgs []*Package) (a []*Package) {
+	for _, p := range pkgs {
+		if p.Error == nil && !p.Standard {
+			a = append(a, p)
+		}
+	}
+	return a
+}
+
+// Unbuildable returns the packages in pkgs that loaded
+// without error but have no Go files to build, not counting
+// tests, such as a directory holding only _test.go files,
+// or files all for other targets. Copying them is pointless,
+// and usually means something went wrong in resolving them.
+func Unbuildable(pkgs []*Package) (a []*Package) {
+	for _, p := range pkgs {
+		if p.Error == nil && p.Package != nil && !p.include && len(p.GoFiles)+len(p.CgoFiles) == 0 {
+			a = append(a, p)
+		}
+	}
+	return
+}
+
+// Vendored returns the list of dependencies
+// of the given packages that are already vendored
+// inside cwd.
+func (r *Resolver) Vendored(packages []*Package) (deps []*Package) {
+	for _, p := range packages {
+		for _, d := range p.deps {
+			if _, ok := r.unvendoredPath(d.ImportPath); ok && r.inCWD(d.Dir) {
+				deps = append(deps, d)
+			}
+		}
+	}
+	sort.Sort(byImportPath(deps))
+	return deps
+}
+
+// A Conflict is an import path that resolves to
+// different source directories for different importers.
+type Conflict struct {
+	ImportPath string   // as seen from outside any vendor tree
+	Dirs       []string // sorted
+}
+
+// Conflicts finds the import paths among the dependencies
+// of the given packages that resolve to more than one
+// source directory, such as a package vendored by one root
+// and found in $GOPATH by another.
+func (r *Resolver) Conflicts(packages []*Package) []Conflict {
+	dirs := make(map[string]map[string]bool)
+	for _, p := range packages {
+		for _, d := range p.deps {
+			if d.Dir == "" || r.isRoot(d) {
+				continue
+			}
+			path, _ := r.unvendoredPath(d.ImportPath)
+			if dirs[path] == nil {
+				dirs[path] = make(map[string]bool)
+			}
+			dirs[path][d.Dir] = true
+		}
+	}
+	var a []Conflict
+	for path, set := range dirs {
+		if len(set) < 2 {
+			continue
+		}
+		cf := Conflict{ImportPath: path}
+		for dir := range set {
+			cf.Dirs = append(cf.Dirs, dir)
+		}
+		sort.Strings(cf.Dirs)
+		a = append(a, cf)
+	}
+	sort.Sort(byConflictPath(a))
+	return a
+}
+
+// Shadows finds the dependencies to copy, among deps, whose
+// import paths, as seen from outside any vendor tree, are
+// those of packages in roots, the root packages in cwd.
+// Such a dependency, perhaps reached through a dependency's
+// own vendor tree, would shadow our own package.
+// It returns a Conflict for each, listing the directory of
+// our package and that of the dependency, in that order.
+func (r *Resolver) Shadows(roots, deps []*Package) []Conflict {
+	rootDir := make(map[string]string)
+	for _, p := range roots {
+		if p.Package != nil && r.isRoot(p) {
+			rootDir[p.ImportPath] = p.Dir
+		}
+	}
+	var a []Conflict
+	seen := make(map[string]bool)
+	for _, d := range deps {
+		path, _ := r.unvendoredPath(d.ImportPath)
+		dir, ok := rootDir[path]
+		if !ok || d.Dir == dir || seen[d.Dir] {
+			continue
+		}
+		seen[d.Dir] = true
+		a = append(a, Conflict{ImportPath: path, Dirs: []string{dir, d.Dir}})
+	}
+	sort.Stable(byConflictPath(a))
+	return a
+}
+
+type byConflictPath []Conflict
+
+func (a byConflictPath) Len() int           { return len(a) }
+func (a byConflictPath) Less(i, j int) bool { return a[i].ImportPath < a[j].ImportPath }
+func (a byConflictPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// unvendoredPath returns the import path that the vendored
+// import path p was expanded from, and whether p is vendored at all.
+// For example, x/y/vendor/z/w (with outDir "vendor") yields z/w.
+func (r *Resolver) unvendoredPath(p string) (string, bool) {
+	vdir := filepath.ToSlash(r.outDir) + "/"
+	if strings.HasPrefix(p, vdir) {
+		return p[len(vdir):], true
+	}
+	if i := strings.LastIndex(p, "/"+vdir); i >= 0 {
+		return p[i+len(vdir)+1:], true
+	}
+	return p, false
+}
+
+// isSeen reports whether pkg is one of the packages
+// with import paths in seen, or in a subdirectory of one,
+// whose directory tree, copied whole, includes pkg's.
+// A package that merely shares a string prefix, such as
+// a/bc after a/b, is not seen.
+func isSeen(pkg *Package, seen []string) bool {
+	for _, prefix := range seen {
+		if hasPathPrefix(pkg.ImportPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// An importStack is a stack of import paths.
+type importStack []string
+
+func (s *importStack) push(p string) {
+	*s = append(*s, p)
+}
+
+func (s *importStack) pop() {
+	*s = (*s)[0 : len(*s)-1]
+}
+
+func (s *importStack) copy() []string {
+	return append([]string{}, *s...)
+}
+
+// shorterThan returns true if sp is shorter than t.
+// We use this to record the shortest import sequence
+// that leads to a particular package.
+func (sp *importStack) shorterThan(t []string) bool {
+	s := *sp
+	if len(s) != len(t) {
+		return len(s) < len(t)
+	}
+	// If they are the same length, settle ties using string ordering.
+	for i := range s {
+		if s[i] != t[i] {
+			return s[i] < t[i]
+		}
+	}
+	return false // they are equal
+}
+
+// A Package describes a single package found in a directory.
+type Package struct {
+	*build.Package
+	Standard   bool          // is this package part of the standard Go library?
+	Error      *PackageError // error loading this package (not dependencies)
+	loadedDeps bool
+	// loadedImports is set once the imports of p's non-test
+	// files are loaded, so that tests may import packages
+	// that import p.
+	loadedImports bool
+	deps          []*Package
+	imports       []*Package // direct imports, including those of tests
+	include       bool       // not a package, but a cgo include directory; see withIncludes
+	usesCgo       bool       // imports "C"
+}
+
+func (p *Package) copyBuild(pp *build.Package) {
+	p.Package = pp
+	p.Standard = p.Goroot && p.ImportPath != "" && !strings.Contains(p.ImportPath, ".")
+}
+
+// packages returns the packages named by the
+// command line arguments 'args'.  If there is an error
+// loading the package (for example, if the directory does not exist),
+// then packages returns a *Package for that argument with p.Error != nil.
+func (r *Resolver) packages(args []string) []*Package {
+	var pkgs []*Package
+	var stk importStack
+	var set = make(map[string]bool)
+
+	for _, arg := range args {
+		if !set[arg] {
+			pkgs = append(pkgs, r.loadPackage(arg, &stk))
+			set[arg] = true
+		}
+	}
+
+	return pkgs
+}
+
+// loadPackage is like loadImport but is used for command-line arguments,
+// not for paths found in import statements.  In addition to ordinary import paths,
+// loadPackage accepts pseudo-paths beginning with cmd/ to denote commands
+// in the Go command directory, as well as paths to those directories.
+func (r *Resolver) loadPackage(arg string, stk *importStack) *Package {
+	// If it is a local import path but names a standard package,
+	// we treat it as if the user specified the standard package.
+	// This lets you run go test ./ioutil in package io and be
+	// referring to io/ioutil rather than a hypothetical import of
+	// "./ioutil".
+	if build.IsLocalImport(arg) {
+		if ip := r.dirImportPath(filepath.Join(r.cwd, arg)); ip != "" {
+			arg = ip
+		}
+		return r.loadImport(arg, r.cwd, nil, stk, nil)
+	}
+	// An import path, as given by -add, is resolved as if
+	// imported from cwd, so that a vendored copy is used.
+	return r.loadImport(arg, r.cwd, r.cwdPackage(), stk, nil)
+}
+
+// cwdPackage returns a Package for cwd, from which
+// to resolve import paths given on the command line.
+func (r *Resolver) cwdPackage() *Package {
+	bp, _ := r.ctxt.ImportDir(r.cwd, build.FindOnly)
+	bp.ImportPath = r.dirImportPath(r.cwd)
+	return &Package{Package: bp}
+}
+
+// loadImport scans the directory named by path, which must be a non-local import path.
+// It returns a *Package describing the package found in that directory.
+func (r *Resolver) loadImport(path, srcDir string, parent *Package, stk *importStack, importPos []token.Position) *Package {
+	stk.push(path)
+	defer stk.pop()
+
+	// Determine canonical identifier for this package.
+	// For a local import the identifier is the pseudo-import path
+	// we create from the full directory to the package.
+	// Otherwise it is the usual import path.
+	// For vendored imports, it is the expanded form.
+	importPath := path
+	path, vendorSearch, vendorErr := r.vendoredImportPath(parent, path)
+	vendored := path != importPath
+	importPath = path
+
+	if p := r.packageCache[importPath]; p != nil {
+		return reusePackage(p, stk)
+	}
+
+	p := new(Package)
+	r.packageCache[importPath] = p
+
+	// Load package.
+	// Import always returns bp != nil, even if an error occurs,
+	// in order to return partial information.
+	//
+	// TODO: After Go 1, decide when to pass build.AllowBinary here.
+	// See issue 3268 for mistakes to avoid.
+	var bp *build.Package
+	var err error
+	if dir, root := r.mappedDir(path); dir != "" && !vendored {
+		bp, err = r.ctxt.ImportDir(dir, build.ImportComment)
+		// Keep findLicenses and the like inside the mapped tree.
+		bp.SrcRoot = filepath.Dir(root)
+		vendorSearch = nil
+	} else if dir := r.snapshotDir(path); dir != "" && !vendored {
+		bp, err = r.ctxt.ImportDir(dir, build.ImportComment)
+		// Keep findLicenses and the like inside the snapshot.
+		bp.SrcRoot = r.snapshot
+		vendorSearch = nil
+	} else {
+		bp, err = r.ctxt.Import(path, srcDir, build.ImportComment|build.IgnoreVendor)
+	}
+	if err != nil && r.mainModule != nil && !build.IsLocalImport(path) {
+		if dir, modDir := r.mainModule.find(path, r.modCache()); dir != "" {
+			bp, err = r.ctxt.ImportDir(dir, build.ImportComment)
+			// Keep findLicenses and the like inside the module.
+			bp.SrcRoot = filepath.Dir(modDir)
+			vendorSearch = nil
+		}
+	}
+
+	// If we got an error from go/build about package not found,
+	// it contains the directories from $GOROOT and $GOPATH that
+	// were searched. Add to that message the vendor directories
+	// that were searched.
+	if err != nil && len(vendorSearch) > 0 {
+		// NOTE(rsc): The direct text manipulation here is fairly awful,
+		// but it avoids defining new go/build API (an exported error type)
+		// late in the Go 1.5 release cycle. If this turns out to be a more general
+		// problem we could define a real error type when the decision can be
+		// considered more carefully.
+		text := err.Error()
+		if strings.Contains(text, "cannot find package \"") && strings.Contains(text, "\" in any of:\n\t") {
+			old := strings.SplitAfter(text, "\n")
+			lines := []string{old[0]}
+			for _, dir := range vendorSearch {
+				lines = append(lines, "\t"+dir+" (vendor tree)\n")
+			}
+			lines = append(lines, old[1:]...)
+			err = errors.New(strings.Join(lines, ""))
+		}
+	}
+	if err == nil {
+		err = vendorErr
+	}
+	// Under -ignore-vendor-errors, a broken copy in our vendor
+	// tree gives way to the package it was copied from, which
+	// Copy then copies over it.
+	if err != nil && vendored && r.IgnoreVendorErrors && bp.Dir != "" && inDir(bp.Dir, filepath.Join(r.cwd, r.outDir)) {
+		up, _ := r.unvendoredPath(importPath)
+		r.warnf(r.stderr(), up, "ignoring error in vendored copy of %s: %v", up, err)
+		stk.pop()
+		q := r.loadImport(up, srcDir, nil, stk, importPos)
+		stk.push(up)
+		r.packageCache[importPath] = q
+		return q
+	}
+	bp.ImportPath = importPath
+	// BinDir is only for show, as in -list-format; a
+	// command is vendored like any other package.
+	if r.gobin != "" {
+		bp.BinDir = r.gobin
+	}
+	// The package is copied to its import path, so that is
+	// where it lands in the vendor tree. An import comment
+	// naming another path is an error, except in a copy
+	// already vendored, in our vendor tree or a dependency's,
+	// which the go tool doesn't check either.
+	if err == nil && !vendored {
+		err = checkImportComment(bp, path)
+	}
+	p.copyBuild(bp)
+	if p.Standard && r.isVendorGoroot(importPath) {
+		p.Standard = false
+	}
+	if p.Standard {
+		return p
+	}
+	if err == nil && !r.inCWD(p.Dir) && !r.isAllowed(importPath) {
+		up, _ := r.unvendoredPath(importPath)
+		if len(*stk) > 1 {
+			err = fmt.Errorf("package %s, needed by root %s, is not allowed by -allow", up, (*stk)[0])
+		} else {
+			err = fmt.Errorf("package %s is not allowed by -allow", up) // from -add
+		}
+	}
+	if err == nil && r.RequireVCS && !r.inCWD(p.Dir) && r.unversioned(p) {
+		err = fmt.Errorf("package %s in %s is not under version control (-require-vcs)", importPath, p.Dir)
+	}
+	r.loadDeps(p, stk, err)
+	if p.Error != nil && len(importPos) > 0 {
+		pos := importPos[0]
+		pos.Filename = r.shortPath(pos.Filename)
+		p.Error.Pos = pos.String()
+	}
+	return p
+}
+
+// loadDeps loads p's deps
+// it omits the standard library
+func (r *Resolver) loadDeps(p *Package, stk *importStack, err error) {
+	if err != nil {
+		p.Error = &PackageError{
+			ImportStack: stk.copy(),
+			Err:         err.Error(),
+		}
+		return
+	}
+
+	// Check for case-insensitive collision of input files.
+	// To avoid problems on case-insensitive files, we reject any package
+	// where two different input files have equal names under a case-insensitive
+	// comparison.
+	f1, f2 := foldDup(stringList(
+		p.GoFiles,
+		p.CgoFiles,
+		p.IgnoredGoFiles,
+		p.CFiles,
+		p.CXXFiles,
+		p.MFiles,
+		p.HFiles,
+		p.SFiles,
+		p.SysoFiles,
+		p.SwigFiles,
+		p.SwigCXXFiles,
+		p.TestGoFiles,
+		p.XTestGoFiles,
+	))
+	if f1 != "" {
+		p.Error = &PackageError{
+			ImportStack: stk.copy(),
+			Err:         fmt.Sprintf("case-insensitive file name collision: %q and %q", f1, f2),
+		}
+		return
+	}
+
+	// Build list of imported packages and full dependency list.
+	deps := make(map[string]*Package)
+	direct := make(map[string]bool)
+	imports := stringList(p.Imports, p.TestImports, p.XTestImports)
+	if r.TestDeps == "roots" && !r.isRoot(p) {
+		imports = p.Imports
+	}
+	for i, path := range imports {
+		if r.ctx.Err() != nil {
+			// Stop loading. Resolve reports why.
+			break
+		}
+		if i == len(p.Imports) {
+			p.loadedImports = true
+		}
+		if path == "C" {
+			p.usesCgo = true
+			continue
+		}
+		if r.isExcluded(path) {
+			continue
+		}
+		if build.IsLocalImport(path) {
+			p.Error = &PackageError{
+				ImportStack: stk.copy(),
+				Err:         fmt.Sprintf("local import %q in non-local package", path),
+			}
+			pos := p.Package.ImportPos[path]
+			if len(pos) > 0 {
+				p.Error.Pos = pos[0].String()
+			}
+			return
+		}
+		p1 := r.loadImport(path, p.Dir, p, stk, p.Package.ImportPos[path])
+		path = p1.ImportPath
+		if i < len(p.Imports) {
+			p.Imports[i] = path
+		}
+		if p1.Standard || p1 == p {
+			continue
+		}
+		if !direct[path] {
+			direct[path] = true
+			p.imports = append(p.imports, p1)
+		}
+		deps[path] = p1
+		for _, dep := range p1.deps {
+			deps[dep.ImportPath] = dep
+		}
+	}
+	p.loadedImports = true
+	p.loadedDeps = true
+
+	depErrors := false
+	depPaths := make([]string, 0, len(deps))
+	for path, p1 := range deps {
+		depPaths = append(depPaths, path)
+		p.deps = append(p.deps, p1)
+		if p1.Error != nil {
+			depErrors = true
+		}
+	}
+	sort.Strings(depPaths)
+	sort.Sort(byImportPath(p.deps))
+
+	// In the absence of errors lower in the dependency tree,
+	// check for case-insensitive collisions of import paths.
+	if !depErrors {
+		dep1, dep2 := foldDup(depPaths)
+		if dep1 != "" {
+			p.Error = &PackageError{
+				ImportStack: stk.copy(),
+				Err:         fmt.Sprintf("case-insensitive import collision: %q and %q", dep1, dep2),
+			}
+			return
+		}
+	}
+}
+
+// checkImportComment returns an error if bp has an import
+// comment naming a path other than path.
+func checkImportComment(bp *build.Package, path string) error {
+	if bp.ImportComment != "" && bp.ImportComment != path {
+		return fmt.Errorf("code in directory %s expects import %q", bp.Dir, bp.ImportComment)
+	}
+	return nil
+}
+
+// isExcluded reports whether path matches
+// one of the patterns given to -exclude.
+func (r *Resolver) isExcluded(path string) bool {
+	for _, match := range r.excluded {
+		if match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed reports whether the package with import path
+// path may be vendored: whether it matches one of the
+// prefixes given to -allow, or there are none.
+func (r *Resolver) isAllowed(path string) bool {
+	if len(r.allowed) == 0 {
+		return true
+	}
+	path, _ = r.unvendoredPath(path)
+	for _, match := range r.allowed {
+		if match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVendorGoroot reports whether the package with import
+// path path, if it is in GOROOT, should be vendored anyway:
+// whether it matches one of the prefixes given to -vendor-goroot.
+func (r *Resolver) isVendorGoroot(path string) bool {
+	for _, match := range r.vendorGoroot {
+		if match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot reports whether p is one of our own packages,
+// in cwd but not in a vendor tree.
+func (r *Resolver) isRoot(p *Package) bool {
+	_, vendored := r.unvendoredPath(p.ImportPath)
+	return r.inCWD(p.Dir) && !vendored
+}
+
+func (r *Resolver) isDir(path string) bool {
+	result, ok := r.isDirCache[path]
+	if ok {
+		return result
+	}
+
+	fi, err := os.Stat(path)
+	result = err == nil && fi.IsDir()
+	r.isDirCache[path] = result
+	return result
+}
+
+// vendoredImportPath returns the expansion of path when it appears in parent.
+// If parent is x/y/z, then path might expand to x/y/z/vendor/path, x/y/vendor/path,
+// x/vendor/path, vendor/path, or else stay x/y/z if none of those exist.
+// vendoredImportPath returns the expanded path or, if no expansion is found, the original.
+// If no epxansion is found, vendoredImportPath also returns a list of vendor directories
+// it searched along the way, to help prepare a useful error message should path turn
+// out not to exist.
+// If parent's directory is not inside its root, vendoredImportPath returns
+// the original path and an error.
+// It skips paths being updated (see updating),
+// and, under -since, paths whose sources have changed.
+// It looks for directories named outDir (flag -o) rather than
+// always "vendor", except in dependencies' own trees under -nested.
+func (r *Resolver) vendoredImportPath(parent *Package, path string) (found string, searched []string, err error) {
+	if parent == nil {
+		return path, nil, nil
+	}
+	if r.updating(path) {
+		return path, nil, nil
+	}
+	dir := filepath.Clean(parent.Dir)
+	vdir := r.outDir
+	if !r.inCWD(dir) {
+		if !r.Nested || parent.Root == "" {
+			// We consider vendored packages only for the root set
+			// we're trying to operate on, not its dependencies.
+			return path, nil, nil
+		}
+		// Under -nested, a dependency's own vendor
+		// directories come first, as for the go tool.
+		vdir = "vendor"
+	}
+	if r.changedSince(path) {
+		return path, nil, nil
+	}
+	root := filepath.Join(parent.Root, "src")
+	inModule := parent.Root == "" && r.mainModule != nil && r.mainModule.contains(dir)
+	if inModule {
+		root = r.mainModule.dir
+	}
+	if !inModule && !inDir(dir, root) {
+		// One of them might have been reached by way of a
+		// symlink, and the other not.
+		dir, root = r.realPath(dir), r.realPath(root)
+	}
+	if !inModule && !inDir(dir, root) {
+		err := fmt.Errorf("invalid vendoredImportPath: dir=%q root=%q separator=%q", dir, root, string(filepath.Separator))
+		return path, nil, err
+	}
+	vpath := filepath.ToSlash(vdir) + "/" + path
+	for i := len(dir); i >= len(root); i-- {
+		if i < len(dir) && dir[i] != filepath.Separator {
+			continue
+		}
+		// Note: checking for the vendor directory before checking
+		// for the vendor/path directory helps us hit the
+		// isDir cache more often. It also helps us prepare a more useful
+		// list of places we looked, to report when an import is not found.
+		if !r.isDir(filepath.Join(dir[:i], vdir)) {
+			continue
+		}
+		targ := filepath.Join(dir[:i], vpath)
+		r.log(r.stderr(), levelSearch, event{Event: "search", ImportPath: path, Dir: targ, Message: "search " + targ + " for " + path})
+		if r.isDir(targ) && inModule {
+			return r.mainModule.importPath(dir[:i]) + "/" + vpath, nil, nil
+		}
+		if r.isDir(targ) {
+			// We started with parent's dir c:\gopath\src\foo\bar\baz\quux\xyzzy.
+			// We know the import path for parent's dir.
+			// We chopped off some number of path elements and
+			// added vendor\path to produce c:\gopath\src\foo\bar\baz\vendor\path.
+			// Now we want to know the import path for that directory.
+			// Construct it by chopping the same number of path elements
+			// (actually the same number of bytes) from parent's import path
+			// and then append /vendor/path.
+			chopped := len(dir) - i
+			if chopped == len(parent.ImportPath)+1 {
+				// We walked up from c:\gopath\src\foo\bar
+				// and found c:\gopath\src\vendor\path.
+				// We chopped \foo\bar (length 8) but the import path is "foo/bar" (length 7).
+				// Use "vendor/path" without any prefix.
+				return vpath, nil, nil
+			}
+			return parent.ImportPath[:len(parent.ImportPath)-chopped] + "/" + vpath, nil, nil
+		}
+		// Note the existence of a vendor directory in case path is not found anywhere.
+		searched = append(searched, targ)
+	}
+	return path, searched, nil
+}
+
+// A PackageError describes an error loading information about a package.
+type PackageError struct {
+	ImportStack   []string // shortest path from package named on command line to this one
+	Pos           string   // position of error
+	Err           string   // the error itself
+	isImportCycle bool     // the error is an import cycle
+	hard          bool     // whether the error is soft or hard; soft errors are ignored in some places
+}
+
+func (p *PackageError) Error() string {
+	// Import cycles deserve special treatment.
+	if p.isImportCycle {
+		return fmt.Sprintf("%s\npackage %s\n", p.Err, strings.Join(p.ImportStack, "\n\timports "))
+	}
+	if p.Pos != "" {
+		// Omit import stack.  The full path to the file where the error
+		// is the most important thing.
+		return p.Pos + ": " + p.Err
+	}
+	if len(p.ImportStack) == 0 {
+		return p.Err
+	}
+	return "package " + strings.Join(p.ImportStack, "\n\timports ") + ": " + p.Err
+}
+
+// IsImportCycle reports whether the error is an import cycle.
+// ImportCycles describes every cycle more clearly.
+func (p *PackageError) IsImportCycle() bool {
+	return p.isImportCycle
+}
+
+// inCWD reports whether path is cwd or inside it.
+// It compares the cleaned paths and then, failing that,
+// the paths with symbolic links resolved. See withinDir.
+func (r *Resolver) inCWD(path string) bool {
+	path, dir := filepath.Clean(path), filepath.Clean(r.cwd)
+	if withinDir(path, dir) {
+		return true
+	}
+	return withinDir(r.realPath(path), r.realPath(dir))
+}
+
+// foldPaths is whether the file system is usually
+// case-insensitive, so that paths differing only in case
+// name the same file, as by default on macOS and Windows.
+var foldPaths = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// withinDir reports whether path is dir or inside it,
+// ignoring case if foldPaths is set.
+// It assumes path and dir are clean.
+func withinDir(path, dir string) bool {
+	if foldPaths {
+		path, dir = toFold(path), toFold(dir)
+	}
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		// A root, such as / or C:\.
+		return strings.HasPrefix(path, dir)
+	}
+	return path == dir || inDir(path, dir)
+}
+
+// inDir reports whether path is strictly inside dir.
+// It assumes path and dir are clean.
+func inDir(path, dir string) bool {
+	return len(path) > len(dir) && strings.HasPrefix(path, dir) && path[len(dir)] == filepath.Separator
+}
+
+// realPath returns path with any symbolic links resolved,
+// or path itself if they can't be.
+func (r *Resolver) realPath(path string) string {
+	if rp, ok := r.realPathCache[path]; ok {
+		return rp
+	}
+	rp, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		rp = path
+	}
+	r.realPathCache[path] = rp
+	return rp
+}
+
+func (r *Resolver) matchPackagesInFS(pattern string) []string {
+	// Find directory to begin the scan.
+	// Could be smarter but this one optimization
+	// is enough for now, since ... is usually at the
+	// end of a path.
+	i := strings.Index(pattern, "...")
+	dir, _ := pathpkg.Split(pattern[:i])
+
+	// pattern begins with ./ or ../.
+	// path.Clean will discard the ./ but not the ../.
+	// We need to preserve the ./ for pattern matching
+	// and in the returned import paths.
+	prefix := ""
+	if strings.HasPrefix(pattern, "./") {
+		prefix = "./"
+	}
+	match := matchPattern(pattern)
+
+	var pkgs []string
+	filepath.Walk(filepath.Join(r.cwd, dir), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		// Make path relative to cwd again. Rel also cleans it,
+		// converting a path like "./io/" to "io". Without this step,
+		// running "cd $GOROOT/src; go list ./io/..." would incorrectly
+		// skip the io package, because prepending the prefix "./" to
+		// the unclean path would result in "././io", and
+		// match("././io") returns false.
+		path, _ = filepath.Rel(r.cwd, path)
+
+		// Avoid .foo, _foo, and testdata directory trees, but do not avoid "." or "..".
+		_, elem := filepath.Split(path)
+		dot := strings.HasPrefix(elem, ".") && elem != "." && elem != ".."
+		if dot || strings.HasPrefix(elem, "_") || elem == "testdata" {
+			return filepath.SkipDir
+		}
+
+		name := prefix + filepath.ToSlash(path)
+		if !match(name) {
+			return nil
+		}
+		if _, err = build.ImportDir(filepath.Join(r.cwd, path), 0); err != nil {
+			if _, noGo := err.(*build.NoGoError); !noGo {
+				r.logError(r.stderr(), name, err)
+			}
+			return nil
+		}
+		pkgs = append(pkgs, name)
+		return nil
+	})
+	return pkgs
+}
+
+// reusePackage reuses package p to satisfy the import at the top
+// of the import stack stk.  If this use causes an import loop,
+// reusePackage updates p's error information to record the loop.
+func reusePackage(p *Package, stk *importStack) *Package {
+	// We use p.loadedImports to detect a package that
+	// is in the midst of its own loadImport call
+	// (all the recursion below happens before p.loadedImports gets set).
+	// Standard packages never load their deps.
+	if !p.loadedImports && !p.Standard {
+		if p.Error == nil {
+			p.Error = &PackageError{
+				ImportStack:   stk.copy(),
+				Err:           "import cycle not allowed",
+				isImportCycle: true,
+			}
+		}
+	}
+	// Don't rewrite the import stack in the error if we have an import cycle.
+	// If we do, we'll lose the path that describes the cycle.
+	if p.Error != nil && !p.Error.isImportCycle && stk.shorterThan(p.Error.ImportStack) {
+		p.Error.ImportStack = stk.copy()
+	}
+	return p
+}
+
+// matchPattern(pattern)(name) reports whether
+// name matches pattern.  Pattern is a limited glob
+// pattern in which '...' means 'any string' and there
+// is no other special syntax.
+func matchPattern(pattern string) func(name string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	// Special case: foo/... matches foo too.
+	if strings.HasSuffix(re, `/.*`) {
+		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+	}
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return reg.MatchString
+}
+
+// copyDeps copies deps into the vendor tree,
+// skipping any package whose directory was
+// already copied along with an earlier one.
+// It also copies the cgo include directories
+// that deps name elsewhere in their repositories.
+// Under -licenses, it warns about each package
+// with no license file.
+// It copies up to *jobs packages at a time, but prints
+// output for each package in order, as if copying
+// them one by one.
+// Under -stats, it finishes with a summary of the files
+// copied for each package, largest first.
+// It reports whether there were no errors.
+func (r *Resolver) copyDeps(deps []*Package) (ok bool) {
+	r.listFiles(deps)
+	r.listEmbeds(deps)
+	r.listGoMods(deps)
+	r.written = make(map[string]string)
+	pkgs := r.copyList(deps)
+	r.copied = pkgs
+
+	type result struct {
+		update bool // pkg was already in the vendor tree
+		ok     bool
+		stats  copyStats
+		stderr bytes.Buffer
+		done   chan struct{}
+	}
+	results := make([]result, len(pkgs))
+	for i := range results {
+		_, err := os.Stat(r.vendorDir(pkgs[i]))
+		results[i].update = err == nil && !r.cleaned
+		results[i].done = make(chan struct{})
+	}
+	prog := r.newProgress(len(pkgs))
+	defer prog.finish()
+	work := make(chan int)
+	go func() {
+		for i := range pkgs {
+			work <- i
+		}
+		close(work)
+	}()
+	n := r.Jobs
+	if n < 1 {
+		n = 1
+	}
+	for j := 0; j < n; j++ {
+		go func() {
+			buf := make([]byte, copyBufSize)
+			for i := range work {
+				res := &results[i]
+				if r.ctx.Err() != nil {
+					close(res.done)
+					continue
+				}
+				res.ok = r.copyDep(pkgs[i], buf, &res.stats, &res.stderr)
+				close(res.done)
+			}
+		}()
+	}
+
+	ok = true
+	unlicensed := 0
+	for i, pkg := range pkgs {
+		res := &results[i]
+		<-res.done
+		if r.ctx.Err() != nil {
+			// Let the other copies stop before returning.
+			// Copy reports why.
+			for j := i + 1; j < len(results); j++ {
+				<-results[j].done
+			}
+			return false
+		}
+		if r.Verbosity >= levelVerbose || r.DryRun {
+			// Labeled once the copy is done, since
+			// an up-to-date copy is left alone.
+			kind := "add"
+			switch {
+			case res.stats.unchanged:
+				kind = "unchanged"
+			case res.update:
+				kind = "update"
+			}
+			e := event{Event: kind, ImportPath: pkg.ImportPath, Dir: pkg.Dir, Dest: r.vendorDir(pkg), Message: kind + " " + pkg.ImportPath}
+			r.writeEvent(r.stdout(), levelVerbose, e)
+		}
+		if res.stderr.Len() > 0 {
+			prog.clear()
+			r.stderr().Write(res.stderr.Bytes())
+		}
+		if !res.ok {
+			ok = false
+		}
+		if pkg.Package != nil && len(pkg.SwigFiles)+len(pkg.SwigCXXFiles) > 0 {
+			prog.clear()
+			r.warnf(r.stderr(), pkg.ImportPath, "%s uses SWIG; building it runs swig, which must be installed", pkg.ImportPath)
+		}
+		if r.Licenses && !pkg.include && len(findLicenses(pkg)) == 0 {
+			prog.clear()
+			r.warnf(r.stderr(), pkg.ImportPath, "no license found for %s", pkg.ImportPath)
+			unlicensed++
+		}
+		// Counted here, in order, rather than by the copy
+		// workers, so that only this goroutine writes to stderr.
+		prog.done()
+	}
+	prog.finish()
+	if unlicensed > 0 {
+		r.warnf(r.stderr(), "", "%d package(s) with no license", unlicensed)
+	}
+
+	r.summary = Summary{}
+	for i, pkg := range pkgs {
+		switch {
+		case pkg.include || !results[i].ok:
+		case results[i].stats.unchanged:
+			r.summary.Unchanged++
+		case results[i].update:
+			r.summary.Updated++
+		default:
+			r.summary.Added++
+		}
+	}
+
+	if r.Stats {
+		var total copyStats
+		var a []pkgStats
+		for i, pkg := range pkgs {
+			a = append(a, pkgStats{pkg.ImportPath, results[i].stats})
+			total.files += results[i].stats.files
+			total.bytes += results[i].stats.bytes
+		}
+		sort.Stable(byBytes(a))
+		for _, ps := range a {
+			fmt.Fprintf(r.stdout(), "%12d bytes %6d files  %s\n", ps.bytes, ps.files, ps.importPath)
+		}
+		fmt.Fprintf(r.stdout(), "%12d bytes %6d files  total\n", total.bytes, total.files)
+	}
+	return ok
+}
+
+// copyList returns the packages copyDeps copies for deps:
+// those whose directories are not inside another's, and the
+// pseudo-packages for their cgo include directories.
+func (r *Resolver) copyList(deps []*Package) []*Package {
+	var pkgs []*Package
+	var seen []string
+	for _, pkg := range r.withIncludes(deps) {
+		if isSeen(pkg, seen) {
+			continue
+		}
+		seen = append(seen, pkg.ImportPath)
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// copyStats counts the files copied for a package.
+type copyStats struct {
+	files     int
+	bytes     int64
+	unchanged bool // the vendored copy was up to date, so left alone
+}
+
+// A Summary counts the packages in the vendor tree by
+// what Copy did with them.
+type Summary struct {
+	Added     int // copied for the first time
+	Updated   int // copied again, replacing an older copy
+	Unchanged int // already vendored, and left alone
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d unchanged", s.Added, s.Updated, s.Unchanged)
+}
+
+// Summary returns the counts of packages added and updated
+// by the last call to Copy. Packages it found up to date
+// count as unchanged, as do those in vendored, as returned
+// by Vendored, which Copy was not given.
+func (r *Resolver) Summary(vendored []*Package) Summary {
+	s := r.summary
+	seen := make(map[string]bool)
+	for _, p := range vendored {
+		if !seen[p.ImportPath] {
+			seen[p.ImportPath] = true
+			s.Unchanged++
+		}
+	}
+	return s
+}
+
+type pkgStats struct {
+	importPath string
+	copyStats
+}
+
+type byBytes []pkgStats
+
+func (a byBytes) Len() int           { return len(a) }
+func (a byBytes) Less(i, j int) bool { return a[i].bytes > a[j].bytes }
+func (a byBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// copyDep copies the files in pkg's directory tree
+// into the vendor tree.
+// It prints any errors to stderr and reports whether
+// there were none.
+//
+// If the old vendored copy is already up to date,
+// copyDep leaves it alone. Otherwise
+// it copies into a temporary directory first, then
+// renames that into place, so that a failed or
+// interrupted copy leaves the old vendored copy intact.
+// Files that are unchanged since the old copy are carried
+// over from it, keeping their modification times.
+// If the rename fails, it falls back to removing the old
+// copy and copying again in place.
+//
+// It counts the files it copies in st.
+// Under -n, it only counts them.
+// It uses buf, if not nil, as scratch space for copying.
+func (r *Resolver) copyDep(pkg *Package, buf []byte, st *copyStats, stderr io.Writer) (ok bool) {
+	dstRoot := r.vendorDir(pkg)
+	if !inDir(dstRoot, r.pkgRoot()) {
+		// An import path such as "../x", perhaps from -from.
+		r.logError(stderr, pkg.ImportPath, fmt.Errorf("package %s: would be copied outside %s; not copying", pkg.ImportPath, r.shortPath(r.pkgRoot())))
+		return false
+	}
+	if !r.cleaned && r.upToDate(pkg, dstRoot) {
+		st.unchanged = true
+		return true
+	}
+	if r.DryRun {
+		return r.copyTree(pkg, dstRoot, "", buf, st, stderr)
+	}
+	tmp, err := mkTempDir(dstRoot)
+	if err != nil {
+		r.logError(stderr, pkg.ImportPath, err)
+		return false
+	}
+	if !r.copyTree(pkg, tmp, dstRoot, buf, st, stderr) {
+		removeAll(tmp)
+		return false
+	}
+	old, err := replaceDir(dstRoot, tmp)
+	if err != nil {
+		removeAll(tmp)
+		if _, err := os.Lstat(dstRoot); err == nil {
+			if err = removeAll(dstRoot); err != nil {
+				r.logError(stderr, pkg.ImportPath, err)
+				return false
+			}
+		}
+		*st = copyStats{}
+		return r.copyTree(pkg, dstRoot, "", buf, st, stderr)
+	}
+	if old != "" {
+		if err = removeAll(old); err != nil {
+			r.logError(stderr, pkg.ImportPath, err)
+			return false
+		}
+	}
+	if r.Fsync {
+		// Make the rename itself durable.
+		if !r.report(stderr, pkg.ImportPath, syncPath(filepath.Dir(dstRoot))) {
+			return false
+		}
+	}
+	// The rename may have touched dstRoot's modification time.
+	if fi, err := os.Stat(pkg.Dir); err == nil {
+		r.report(stderr, pkg.ImportPath, copyModTime(dstRoot, fi))
+	}
+	return true
+}
+
+// upToDate reports whether dstRoot already holds exactly
+// the files and directories copyTree would write there for
+// pkg, with the same contents and permissions.
+func (r *Resolver) upToDate(pkg *Package, dstRoot string) bool {
+	if _, err := os.Lstat(dstRoot); err != nil {
+		return false
+	}
+	want := make(map[string]bool)
+	same := true
+	check := func(dst, src string, fi os.FileInfo) error {
+		if !same {
+			return nil
+		}
+		if err := r.claimPath(dst); err != nil {
+			same = false
+			return nil
+		}
+		want[dst] = true
+		dfi, err := os.Stat(dst)
+		if err != nil || dfi.IsDir() != fi.IsDir() || dfi.Mode().Perm() != fi.Mode().Perm() {
+			same = false
+		} else if !fi.IsDir() {
+			eq, err := r.sameCopy(dst, src)
+			same = err == nil && eq
+		}
+		return nil
+	}
+	if !r.walkDep(pkg, dstRoot, ioutil.Discard, check) || !same {
+		return false
+	}
+	for _, src := range r.outsideLicenses(pkg) {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return false
+		}
+		check(filepath.Join(dstRoot, filepath.Base(src)), src, fi)
+	}
+	if !same {
+		return false
+	}
+	filepath.Walk(dstRoot, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !want[path] {
+			same = false
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return same
+}
+
+// copyTree copies the files in pkg's directory tree to dstRoot.
+// If prev is not empty, it names a directory holding an older
+// copy of the tree; files there that are identical to their
+// source are reused rather than copied again.
+//
+// Directories get the permission bits and modification times
+// of their sources, once their contents have been written.
+// A file or directory whose vendored path differs only in
+// case from another's in this copy is an error; see claimPath.
+//
+// It counts the files it copies in st.
+// Under -n, it only counts them.
+// It uses buf, if not nil, as scratch space for copying.
+func (r *Resolver) copyTree(pkg *Package, dstRoot, prev string, buf []byte, st *copyStats, stderr io.Writer) (ok bool) {
+	type dir struct {
+		path string
+		fi   os.FileInfo
+	}
+	var dirs []dir
+	final := r.vendorDir(pkg)
+	ok = r.walkDep(pkg, dstRoot, stderr, func(dst, src string, fi os.FileInfo) error {
+		rel, _ := filepath.Rel(dstRoot, dst)
+		if err := r.claimPath(filepath.Join(final, rel)); err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			st.files++
+			st.bytes += fi.Size()
+		}
+		if r.DryRun {
+			return nil
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, dir{dst, fi})
+			return os.MkdirAll(dst, 0777)
+		}
+		e := event{Event: "copy", ImportPath: pkg.ImportPath, Dir: src, Dest: filepath.Join(final, rel), Message: "copy " + src}
+		r.log(stderr, levelFiles, e)
+		if prev != "" {
+			if r.reuseFile(dst, filepath.Join(prev, rel), src, fi) {
+				return nil
+			}
+		}
+		return r.copyFileBuffer(dst, src, buf)
+	})
+	for _, src := range r.outsideLicenses(pkg) {
+		fi, err := os.Stat(src)
+		if err == nil {
+			st.files++
+			st.bytes += fi.Size()
+			if r.DryRun {
+				continue
+			}
+			name := filepath.Base(src)
+			e := event{Event: "copy", ImportPath: pkg.ImportPath, Dir: src, Dest: filepath.Join(final, name), Message: "copy " + src}
+			r.log(stderr, levelFiles, e)
+			err = r.copyFileBuffer(filepath.Join(dstRoot, name), src, buf)
+		}
+		if !r.report(stderr, pkg.ImportPath, err) {
+			ok = false
+		}
+	}
+	for _, d := range dirs {
+		r.report(stderr, pkg.ImportPath, copyDirMode(d.path, d.fi))
+		r.report(stderr, pkg.ImportPath, copyModTime(d.path, d.fi))
+	}
+	if r.Fsync && ok {
+		// Deepest first, so each directory's entries
+		// are on disk before the directory holding it.
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if !r.report(stderr, pkg.ImportPath, syncPath(dirs[i].path)) {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// copyDirMode gives directory dst the permission bits in fi.
+// Failure is only a warning.
+func copyDirMode(dst string, fi os.FileInfo) error {
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return warning{err}
+	}
+	return nil
+}
+
+// claimPath records that path, in the vendor tree,
+// is being written in this copy. It returns an error if a
+// different path that differs from it only in case was
+// written already: on a case-insensitive file system,
+// such as the default on macOS, one would overwrite
+// the other.
+func (r *Resolver) claimPath(path string) error {
+	fold := toFold(path)
+	r.writtenMu.Lock()
+	defer r.writtenMu.Unlock()
+	if r.written == nil {
+		r.written = make(map[string]string)
+	}
+	if prev, ok := r.written[fold]; ok && prev != path {
+		return fmt.Errorf("case-insensitive file name collision: %s and %s", r.shortPath(prev), r.shortPath(path))
+	}
+	r.written[fold] = path
+	return nil
+}
+
+// syncPath flushes the file or directory name to stable
+// storage. Windows can't sync directories, so there it
+// does nothing for them.
+func syncPath(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if runtime.GOOS == "windows" {
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			return nil
+		}
+	}
+	return osSync(f)
+}
+
+// copyModTime sets the access and modification times
+// of dst to the modification time in fi.
+// Failure is only a warning.
+func copyModTime(dst string, fi os.FileInfo) error {
+	if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+		return warning{err}
+	}
+	return nil
+}
+
+// reuseFile makes dst a copy of old, keeping its modification time,
+// if old has the contents copying src would give it, and the same
+// permissions as src, whose FileInfo is fi. It reports whether it did so.
+func (r *Resolver) reuseFile(dst, old, src string, fi os.FileInfo) bool {
+	ofi, err := os.Stat(old)
+	if err != nil || !ofi.Mode().IsRegular() || ofi.Mode().Perm() != fi.Mode().Perm() {
+		return false
+	}
+	if same, err := r.sameCopy(old, src); err != nil || !same {
+		return false
+	}
+	if os.Link(old, dst) == nil {
+		return true
+	}
+	if r.copyFile(dst, old) != nil {
+		os.Remove(dst)
+		return false
+	}
+	return os.Chtimes(dst, ofi.ModTime(), ofi.ModTime()) == nil
+}
+
+// mkTempDir creates a new empty directory next to dir,
+// with a name starting with "." so that it is ignored
+// by the go tool and by vexp itself.
+// Unlike ioutil.TempDir, it creates the directory with
+// mode 0777 (before umask), as os.MkdirAll would.
+func mkTempDir(dir string) (string, error) {
+	parent, base := filepath.Split(dir)
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return "", err
+	}
+	for i := 0; ; i++ {
+		tmp := filepath.Join(parent, fmt.Sprintf(".%s.vexp%d-%d", base, os.Getpid(), i))
+		err := os.Mkdir(tmp, 0777)
+		if !os.IsExist(err) {
+			return tmp, err
+		}
+	}
+}
+
+// removeAll is like os.RemoveAll, but it also removes
+// read-only files and directories, such as vendored copies
+// of read-only sources, by making them writable first.
+func removeAll(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		return nil
+	}
+	filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode()&os.ModeSymlink == 0 {
+			os.Chmod(p, fi.Mode().Perm()|0200)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
+
+// replaceDir renames tmp to dir. If dir already exists,
+// replaceDir first renames it out of the way, and returns
+// its new name for the caller to remove.
+// If replaceDir returns an error, dir is unchanged.
+func replaceDir(dir, tmp string) (old string, err error) {
+	if _, err := os.Lstat(dir); err == nil {
+		old = tmp + ".old"
+		if err := os.Rename(dir, old); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if old != "" {
+			os.Rename(old, dir)
+		}
+		return "", err
+	}
+	return old, nil
+}
+
+// vendorDir returns the directory pkg is copied into.
+func (r *Resolver) vendorDir(pkg *Package) string {
+	return filepath.Join(r.pkgRoot(), filepath.FromSlash(pkg.ImportPath))
+}
+
+// pkgRoot returns the directory packages are copied into,
+// by import path: the output directory or, under
+// -gopath-layout, its src subdirectory, as in a GOPATH entry.
+func (r *Resolver) pkgRoot() string {
+	if r.GopathLayout {
+		return filepath.Join(r.cwd, r.outDir, "src")
+	}
+	return filepath.Join(r.cwd, r.outDir)
+}
+
+// walkDep calls fn for each file and directory in pkg's
+// directory tree that belongs in the vendor tree,
+// with its source path src and the destination path dst
+// it maps to under dstRoot.
+// It lists the tree first, then calls fn in order of
+// relative path, so that what it does, and logs, is the
+// same from run to run, whatever order the file system
+// lists directories in. A directory comes before its contents.
+// It follows symbolic links, passing fn the FileInfo
+// of the link's target.
+// It prints any errors, from walking or from fn, to stderr,
+// and reports whether there were none.
+// If fn returns a warning, walkDep prints it only under -v
+// and does not count it as an error.
+// If the Resolver's context is done, walkDep stops and
+// reports failure, without printing anything. It also stops,
+// saying so, at any dst that would be outside dstRoot.
+func (r *Resolver) walkDep(pkg *Package, dstRoot string, stderr io.Writer, fn func(dst, src string, fi os.FileInfo) error) (ok bool) {
+	ok = true
+	var files []walkFile
+	unusedDirs := make(map[string]bool) // see unused
+	embedOnly := make(map[string]bool)  // directories entered only for embedded files
+	err := walkLinks(pkg.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err := r.ctx.Err(); err != nil {
+			ok = false
+			return err
+		}
+		if err != nil {
+			r.logError(stderr, pkg.ImportPath, err)
+			ok = false
+			return nil
+		}
+
+		// Files a package embeds are copied whatever
+		// the rules below say; see listEmbeds.
+		rel, _ := filepath.Rel(pkg.Dir, path)
+		if r.embedded[path] {
+			files = append(files, walkFile{rel, path, fi})
+			return nil
+		}
+		if embedOnly[filepath.Dir(path)] && !r.embedDirs[path] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		skipDir := func() error {
+			if r.embedDirs[path] {
+				embedOnly[path] = true
+				files = append(files, walkFile{rel, path, fi})
+				return nil
+			}
+			return filepath.SkipDir
+		}
+
+		_, elem := filepath.Split(path)
+		if r.skipped(elem) {
+			if fi.IsDir() {
+				return skipDir()
+			}
+			return nil
+		}
+		// Under -flatten, avoid vendor directory trees too.
+		// Their packages are vendored at the top level instead.
+		if r.Flatten && fi.IsDir() && elem == "vendor" && path != pkg.Dir {
+			return skipDir()
+		}
+		if r.NoTest && !fi.IsDir() && strings.HasSuffix(elem, "_test.go") {
+			return nil
+		}
+		if !fi.IsDir() && r.isStripped(elem) {
+			return nil
+		}
+		if !fi.IsDir() && r.unselected(path) {
+			return nil
+		}
+		if r.GoFilesOnly && !fi.IsDir() && !sourceExts[filepath.Ext(elem)] && !isLicense(elem) {
+			return nil
+		}
+		if r.MaxFileSize > 0 && !fi.IsDir() && fi.Size() > r.MaxFileSize && filepath.Ext(elem) != ".go" {
+			msg := fmt.Sprintf("skipping %s: larger than %d bytes", r.shortPath(path), r.MaxFileSize)
+			r.log(stderr, levelVerbose, event{Event: "skip", ImportPath: pkg.ImportPath, Dir: path, Message: msg})
+			return nil
+		}
+		// Leave out subpackages we don't use, but not
+		// the packages we do below them.
+		if !pkg.include && path != pkg.Dir {
+			if fi.IsDir() && r.unused(path) {
+				if !r.usedBelow(path) {
+					return skipDir()
+				}
+				unusedDirs[path] = true
+			}
+			if !fi.IsDir() && unusedDirs[filepath.Dir(path)] {
+				return nil
+			}
+		}
+		if !pkg.include && path != pkg.Dir && r.unlisted(path, fi.IsDir()) {
+			if fi.IsDir() {
+				return skipDir()
+			}
+			return nil
+		}
+		if rel != "." && r.isIgnored(pkg.ImportPath, rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return skipDir()
+			}
+			return nil
+		}
+
+		files = append(files, walkFile{rel, path, fi})
+		if fi.IsDir() {
+			files = append(files, r.goModFiles(filepath.Clean(path), rel)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+
+	sort.Sort(byRel(files))
+	for _, f := range files {
+		if r.ctx.Err() != nil {
+			return false
+		}
+		dst, err := joinWithin(dstRoot, f.rel)
+		if err != nil {
+			r.logError(stderr, pkg.ImportPath, err)
+			return false
+		}
+		if !r.report(stderr, pkg.ImportPath, fn(dst, f.src, f.fi)) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// joinWithin joins dir and rel, a relative path, and
+// returns an error if the result is outside dir, as it
+// would be if rel started with "..". It guards against
+// writing outside the vendor tree.
+func joinWithin(dir, rel string) (string, error) {
+	path, dir := filepath.Join(dir, rel), filepath.Clean(dir)
+	if path != dir && !inDir(path, dir) {
+		return "", fmt.Errorf("%s is outside %s; not copying", path, dir)
+	}
+	return path, nil
+}
+
+// skipped reports whether walkDep leaves out files and
+// directory trees named elem: those named .foo, _foo, and
+// testdata, but not . or .., and, under -copy-underscore,
+// not _foo.
+func (r *Resolver) skipped(elem string) bool {
+	dot := strings.HasPrefix(elem, ".") && elem != "." && elem != ".."
+	return dot || strings.HasPrefix(elem, "_") && !r.Underscore || elem == "testdata"
+}
+
+// A walkFile is a file or directory found by walkDep.
+type walkFile struct {
+	rel string // relative to the package directory
+	src string
+	fi  os.FileInfo
+}
+
+// byRel sorts walkFiles by rel, as a slash-separated path,
+// with the package directory itself, ".", first.
+type byRel []walkFile
+
+func (a byRel) Len() int           { return len(a) }
+func (a byRel) Less(i, j int) bool { return a[i].key() < a[j].key() }
+func (a byRel) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func (f walkFile) key() string {
+	if f.rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(f.rel)
+}
+
+// walkLinks is like filepath.Walk, but it follows symbolic
+// links, calling walkFn with the FileInfo of the link's target.
+// To avoid loops, it doesn't descend into a directory that
+// it is already walking, by way of a link.
+func walkLinks(root string, walkFn filepath.WalkFunc) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walkLink(root, fi, make(map[string]bool), walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkLink walks path for walkLinks.
+// Active holds the real paths of the directories
+// being walked.
+func walkLink(path string, fi os.FileInfo, active map[string]bool, walkFn filepath.WalkFunc) error {
+	if !fi.IsDir() {
+		return walkFn(path, fi, nil)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return walkFn(path, fi, err)
+	}
+	if active[real] {
+		return nil // a loop
+	}
+	if err := walkFn(path, fi, nil); err != nil {
+		return err
+	}
+	active[real] = true
+	defer delete(active, real)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return walkFn(path, fi, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return walkFn(path, fi, err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Stat(filename)
+		if err != nil {
+			err = walkFn(filename, nil, err)
+		} else {
+			err = walkLink(filename, fileInfo, active, walkFn)
+		}
+		if err != nil && (err != filepath.SkipDir || fileInfo == nil || !fileInfo.IsDir()) {
+			return err
+		}
+	}
+	return nil
+}
+
+// report prints err, if any, to stderr
+// and reports whether it was nil or only a warning.
+// It prints warnings only under -v.
+func (r *Resolver) report(stderr io.Writer, importPath string, err error) (ok bool) {
+	if w, isWarning := err.(warning); isWarning {
+		r.log(stderr, levelVerbose, event{Event: "warning", ImportPath: importPath, Message: w.error.Error()})
+		return true
+	}
+	if err != nil {
+		r.logError(stderr, importPath, err)
+		return false
+	}
+	return true
+}
+
+// A warning is an error that doesn't stop
+// vexp from doing its job.
+type warning struct {
+	error
+}
+
+func (w warning) Error() string {
+	return "warning: " + w.error.Error()
+}
+
+// osLink is os.Link, replaced in tests.
+var osLink = os.Link
+
+// osReflink is reflink, replaced in tests.
+var osReflink = reflink
+
+// osSync is (*os.File).Sync, replaced in tests.
+var osSync = (*os.File).Sync
+
+// copyFile copies the contents of src to dst,
+// then gives dst the same permission bits
+// and modification time as src.
+// Failure to set either is only a warning.
+// Under -link, it first tries to make dst a hard link to src,
+// and copies only if that fails. Likewise, under -reflink,
+// it first tries to make dst a copy-on-write clone of src.
+// Under -normalize-eol, it rewrites the line endings of
+// source files instead; see copyFileEOL.
+func (r *Resolver) copyFile(dst, src string) error {
+	return r.copyFileBuffer(dst, src, nil)
+}
+
+// copyBufSize is the size of the buffer each copying
+// goroutine uses. Larger buffers mean fewer system calls
+// for large files.
+var copyBufSize = 128 << 10
+
+// copyFileBuffer is like copyFile, but uses buf, if not nil,
+// for copying, rather than allocating a buffer for each file.
+// Where the operating system can copy between files directly,
+// buf goes unused.
+func (r *Resolver) copyFileBuffer(dst, src string, buf []byte) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if r.normalizesEOL(src) {
+		return copyFileEOL(dst, src, r.Fsync)
+	}
+	if r.Link && osLink(src, dst) == nil {
+		return nil
+	}
+	if r.Reflink && osReflink(dst, src) == nil {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if r.Fsync {
+			if err := syncPath(dst); err != nil {
+				return err
+			}
+		}
+		return copyAttrs(dst, fi)
+	}
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyBuffer(df, sf, buf)
+	if err == nil && r.Fsync {
+		err = osSync(df)
+	}
+	if err != nil {
+		df.Close()
+		return err
+	}
+	if err = df.Close(); err != nil {
+		return err
+	}
+	return copyAttrs(dst, fi)
+}
+
+// copyAttrs gives dst, a copy of the file described by fi,
+// the same permission bits and modification time.
+// Failure to set either is only a warning.
+func copyAttrs(dst string, fi os.FileInfo) error {
+	// Chmod after writing, so a read-only source
+	// doesn't keep us from filling in dst.
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return warning{err}
+	}
+	return copyModTime(dst, fi)
+}
+
+type byImportPath []*Package
+
+func (a byImportPath) Len() int           { return len(a) }
+func (a byImportPath) Less(i, j int) bool { return a[i].ImportPath < a[j].ImportPath }
+func (a byImportPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// hasPathPrefix reports whether the path s begins with the
+// elements in prefix.
+func hasPathPrefix(s, prefix string) bool {
+	switch {
+	default:
+		return false
+	case len(s) == len(prefix):
+		return s == prefix
+	case len(s) > len(prefix):
+		if prefix != "" && prefix[len(prefix)-1] == '/' {
+			return strings.HasPrefix(s, prefix)
+		}
+		return s[len(prefix)] == '/' && s[:len(prefix)] == prefix
+	}
+}
+
+// shortPath returns an absolute or relative name for path, whatever is shorter.
+func (r *Resolver) shortPath(path string) string {
+	if rel, err := filepath.Rel(r.cwd, path); err == nil && len(rel) < len(path) {
+		return rel
+	}
+	return path
+}
+
+// stringList's arguments should be a sequence of string or []string values.
+// stringList flattens them into a single []string.
+func stringList(args ...interface{}) []string {
+	var x []string
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case []string:
+			x = append(x, arg...)
+		case string:
+			x = append(x, arg)
+		default:
+			panic("stringList: invalid argument of type " + fmt.Sprintf("%T", arg))
+		}
+	}
+	return x
+}
+
+// toFold returns a string with the property that
+// strings.EqualFold(s, t) iff toFold(s) == toFold(t)
+// This lets us test a large set of strings for fold-equivalent
+// duplicates without making a quadratic number of calls
+// to EqualFold. Note that strings.ToUpper and strings.ToLower
+// have the desired property in some corner cases.
+func toFold(s string) string {
+	// Fast path: all ASCII, no upper case.
+	// Most paths look like this already.
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf || 'A' <= c && c <= 'Z' {
+			goto Slow
+		}
+	}
+	return s
+
+Slow:
+	var buf bytes.Buffer
+	for _, r := range s {
+		// SimpleFold(x) cycles to the next equivalent rune > x
+		// or wraps around to smaller values. Iterate until it wraps,
+		// and we've found the minimum value.
+		for {
+			r0 := r
+			r = unicode.SimpleFold(r0)
+			if r <= r0 {
+				break
+			}
+		}
+		// Exception to allow fast path above: A-Z => a-z
+		if 'A' <= r && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// foldDup reports a pair of strings from the list that are
+// equal according to strings.EqualFold.
+// It returns "", "" if there are no such strings.
+func foldDup(list []string) (string, string) {
+	clash := map[string]string{}
+	for _, s := range list {
+		fold := toFold(s)
+		if t := clash[fold]; t != "" {
+			if s > t {
+				s, t = t, s
+			}
+			return s, t
+		}
+		clash[fold] = s
+	}
+	return "", ""
+}
+
+// SetPlatform sets c to select only the files for
+// the given target system, instead of all files.
+// An empty goos or goarch leaves that part of c unchanged.
+func SetPlatform(c *build.Context, goos, goarch string) {
+	c.UseAllFiles = false
+	if goos != "" {
+		c.GOOS = goos
+	}
+	if goarch != "" {
+		c.GOARCH = goarch
+	}
+}
@@ -0,0 +1,3928 @@
+package vendoring
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFindDeps(t *testing.T) {
+	findDeps := []struct {
+		root, update, want, tab string
+		testdeps                string
+		wantErr                 bool
+	}{
+		{
+			root: "p",
+			want: "d",
+			tab: `
+				p/p.go: package p; import _ "d"
+				d/d.go: package d
+			`,
+		},
+		{
+			// external tests import the package under test
+			root: "p",
+			want: "d q",
+			tab: `
+				p/p.go:      package p; import _ "d"
+				p/p_test.go: package p_test; import _ "p"; import _ "q"
+				d/d.go:      package d
+				d/d_test.go: package d_test; import _ "d"
+				q/q.go:      package q; import _ "p"
+			`,
+		},
+		{
+			root: "p",
+			want: "d e",
+			tab: `
+				p/p.go: package p; import (_ "d"; _ "e"; _ "fmt")
+				d/d.go: package d; import (_ "e"; _ "fmt")
+				e/e.go: package e
+			`,
+		},
+		{
+			root: "p",
+			want: "",
+			tab: `
+				p/p.go:          package p; import _ "d"
+				p/vendor/d/d.go: package d
+				d/d.go:          package d
+			`,
+		},
+		{
+			root:   "p",
+			update: "d",
+			want:   "d",
+			tab: `
+				p/p.go:          package p; import _ "d"
+				p/vendor/d/d.go: package d
+				d/d.go:          package d
+			`,
+		},
+		{
+			root: "p",
+			want: "d q",
+			tab: `
+				p/p.go: package p; import _ "q"
+				q/q.go: package q; import _ "d"
+				d/d.go: package d
+			`,
+		},
+		{
+			root: "p",
+			want: "d q",
+			tab: `
+				p/p.go:          package p; import _ "q"
+				q/q.go:          package q; import _ "d"
+				q/vendor/d/d.go: package d
+				d/d.go:          package d
+			`,
+		},
+		{
+			root: "p",
+			want: "d", // d has Error set
+			tab: `
+				p/p.go: package p; import _ "d"
+			`,
+			wantErr: true,
+		},
+		{
+			root: "p",
+			want: "d q", // d has Error set
+			tab: `
+				p/p.go:          package p; import _ "q"
+				q/q.go:          package q; import _ "d"
+				q/vendor/d/d.go: package d
+			`,
+			wantErr: true,
+		},
+		{
+			// copy test dependencies
+			root: "p",
+			want: "pt q qt xt",
+			tab: `
+				p/p.go:      package p;      import _ "q"
+				p/p_test.go: package p;      import _ "pt"
+				p/x_test.go: package p_test; import _ "xt"
+				q/q.go:      package q
+				q/q_test.go: package q;      import _ "qt"
+				pt/pt.go:    package pt
+				xt/xt.go:    package xt
+				qt/qt.go:    package qt
+			`,
+		},
+		{
+			// copy test dependencies of roots only
+			root:     "p",
+			testdeps: "roots",
+			want:     "pt q xt",
+			tab: `
+				p/p.go:      package p;      import _ "q"
+				p/p_test.go: package p;      import _ "pt"
+				p/x_test.go: package p_test; import _ "xt"
+				q/q.go:      package q
+				q/q_test.go: package q;      import _ "qt"
+				q/x_test.go: package q_test; import _ "qt"
+				pt/pt.go:    package pt
+				xt/xt.go:    package xt
+				qt/qt.go:    package qt
+			`,
+		},
+		{
+			root: "p",
+			want: "d e",
+			tab: `
+				p/p_darwin.go: package p; import _ "d"
+				p/p_linux.go:  package p; import _ "e"
+				d/d.go: package d
+				e/e.go: package e
+			`,
+		},
+		{
+			root: "p",
+			want: "",
+			tab: `
+				p/p.go:   package p; import _ "./d"
+				p/d/d.go: package d
+			`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range findDeps {
+		paths := strings.Fields(test.root)
+		r, clean := setup(t, paths[0], test.tab)
+		defer clean()
+		r.Update = strings.Fields(test.update)
+		r = mustResolver(t, r.Config)
+		if test.testdeps != "" {
+			r.TestDeps = test.testdeps
+		}
+		pkgs := r.packages(paths)
+		deps := r.dependencies(pkgs)
+		if got := anyErr(append(pkgs, deps...)); got != test.wantErr {
+			t.Errorf("dependencies(packages(%q)) error = %v want %v", test.root, got, test.wantErr)
+			t.Logf("flag -u=%q", test.update)
+			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
+		}
+		got := names(deps)
+		want := strings.Fields(test.want)
+		if len(want) == 0 {
+			want = nil
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dependencies(packages(%q)) = %v want %v", test.root, got, want)
+			t.Logf("flag -u=%q", test.update)
+			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
+		}
+		clean()
+	}
+}
+
+func names(ps []*Package) (a []string) {
+	for _, p := range ps {
+		a = append(a, p.ImportPath)
+	}
+	return a
+}
+
+func anyErr(ps []*Package) bool {
+	for _, p := range ps {
+		if p.Error != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setup sets up a test directory using the filesystem table
+// described in tab.
+// It returns a Resolver for a Config whose Context.GOPATH is
+// a new empty directory, populated with the source files and
+// contents in tab, and whose Dir is start in that workspace.
+// clean removes the workspace.
+func setup(t *testing.T, start, tab string) (r *Resolver, clean func()) {
+	wksp, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		t.Fatal("setup", err)
+	}
+	src := filepath.Join(wksp, "src")
+
+	c := New(filepath.Join(src, filepath.FromSlash(start)))
+	c.Context.GOPATH = wksp
+	c.GOBIN = ""
+
+	for _, field := range strings.Split(tab, "\n") {
+		field = strings.TrimSpace(field)
+		i := strings.Index(field, ":")
+		if i < 1 {
+			continue
+		}
+		path := filepath.Join(src, filepath.FromSlash(strings.TrimSpace(field[:i])))
+		body := strings.TrimSpace(field[i+1:]) + "\n"
+		err = os.MkdirAll(filepath.Dir(path), 0777)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(body), 0666)
+		}
+		if err != nil {
+			os.RemoveAll(wksp)
+			t.Fatal("setup", err)
+		}
+	}
+
+	r, err = NewResolver(c)
+	if err != nil {
+		os.RemoveAll(wksp)
+		t.Fatal("setup", err)
+	}
+	return r, func() { os.RemoveAll(wksp) }
+}
+
+// mustResolver returns a new Resolver for c.
+func mustResolver(t testing.TB, c *Config) *Resolver {
+	r, err := NewResolver(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCopyFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := mustResolver(t, New(dir))
+	for _, mode := range []os.FileMode{0644, 0755, 0444} {
+		src := filepath.Join(dir, "src")
+		dst := filepath.Join(dir, "dst")
+		os.Remove(src)
+		os.Remove(dst)
+		if err := ioutil.WriteFile(src, []byte("#!/bin/sh\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(src, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.copyFile(dst, src); err != nil {
+			t.Errorf("copyFile(%v) = %v", mode, err)
+			continue
+		}
+		fi, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != mode {
+			t.Errorf("copyFile(%v) dst mode = %v", mode, got)
+		}
+	}
+}
+
+func TestCopyDirMode(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		d/d.go:          package d
+		d/private/x.txt: x
+		d/open/y.txt:    y
+	`)
+	defer clean()
+	src := filepath.Join(r.Context.GOPATH, "src", "d")
+	modes := map[string]os.FileMode{"private": 0700, "open": 0755}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(src, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for name, mode := range modes {
+		fi, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", name))
+		if err != nil {
+			t.Error(err)
+		} else if got := fi.Mode().Perm(); got != mode {
+			t.Errorf("vendor/d/%s mode = %v want %v", name, got, mode)
+		}
+	}
+}
+func TestCopyOutDir(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:               package p; import _ "d"; import _ "e"
+		p/third_party/e/e.go: package e
+		d/d.go:               package d
+		e/e.go:               package e
+	`)
+	defer clean()
+	r.OutDir = "third_party"
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Error("copyDeps failed")
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "third_party", "d", "d.go")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor")); !os.IsNotExist(err) {
+		t.Errorf("vendor exists, err = %v", err)
+	}
+}
+
+func TestGopathLayout(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:   package p; import _ "d"
+		d/d.go:   package d; import _ "d/e"
+		d/e/e.go: package e
+	`)
+	defer clean()
+	r.OutDir = "gopath"
+	r.GopathLayout = true
+	r = mustResolver(t, r.Config)
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "d/e/e.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "gopath", "src", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "gopath", "d")); !os.IsNotExist(err) {
+		t.Errorf("gopath/d exists, err = %v", err)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		p/vendor/e/e.go: package e
+		d/d.go:          package d
+		d/.git/HEAD:     ref: refs/heads/master
+		d/.git/refs/heads/master: 0123456789abcdef0123456789abcdef01234567
+		e/e.go:          package e
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	deps := r.dependencies(roots)
+	if err := r.WriteManifest(deps, r.Vendored(roots)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Manifest
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(r.Context.GOPATH, "src")
+	want := Manifest{Packages: []ManifestPackage{
+		{
+			ImportPath: "d",
+			Dir:        filepath.Join(src, "d"),
+			Revision:   "0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			ImportPath: "e",
+			Dir:        filepath.Join(src, "e"),
+			Files:      map[string]string{"e.go": fmt.Sprintf("%x", sha256.Sum256([]byte("package e\n")))},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %+v want %+v", got, want)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:   package p; import _ "d"
+		d/d.go:   package d; import _ "d/e"
+		d/e/e.go: package e
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Stderr = &stderr
+
+	roots := r.packages([]string{"p"})
+	deps := r.dependencies(roots)
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	if err := r.WriteManifest(deps, r.Vendored(roots)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := r.VerifyManifest(ctx); err != nil {
+		t.Fatalf("VerifyManifest = %v, want nil\n%s", err, stderr.Bytes())
+	}
+
+	vendor := filepath.Join(r.Dir, "vendor")
+	if err := ioutil.WriteFile(filepath.Join(vendor, "d", "e", "e.go"), []byte("package e // changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(vendor, "d", "d.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(vendor, "d", "x.go"), []byte("package d\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Without the sources, verifying still works.
+	if err := os.RemoveAll(filepath.Join(r.Context.GOPATH, "src", "d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.VerifyManifest(ctx); err == nil {
+		t.Error("VerifyManifest = nil, want error")
+	}
+	want := strings.Join([]string{
+		filepath.Join("vendor", "d", "d.go") + ": missing",
+		filepath.Join("vendor", "d", "e", "e.go") + ": checksum mismatch",
+		filepath.Join("vendor", "d", "x.go") + ": not in manifest",
+	}, "\n") + "\n"
+	if got := stderr.String(); got != want {
+		t.Errorf("stderr = %q want %q", got, want)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:                   package p; import _ "d"; import _ "e/sub"; import _ "f"; import _ "g"
+		p/vendor/g/g.go:          package g
+		p/vendor/g/COPYING:       Copyright (c) 2015 G. Permission is hereby granted, free of charge, to any person
+		d/d.go:                   package d
+		d/LICENSE:                MIT License. Permission is hereby granted, free of charge, to any person
+		d/.git/HEAD:              ref: refs/heads/master
+		d/.git/refs/heads/master: 0123456789abcdef0123456789abcdef01234567
+		e/LICENSE.txt:            Apache License Version 2.0, January 2004
+		e/sub/sub.go:             package sub
+		f/f.go:                   package f
+		f/COPYING:                All rights reserved.
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	var buf bytes.Buffer
+	if err := r.WriteReport(&buf, r.dependencies(roots), r.Vendored(roots)); err != nil {
+		t.Fatal(err)
+	}
+	want := `IMPORT PATH  VERSION       LICENSE
+d            0123456789ab  MIT (LICENSE)
+e/sub        -             Apache-2.0 (LICENSE.txt)
+f            -             unknown (COPYING)
+g            -             MIT (COPYING)
+`
+	if got := buf.String(); got != want {
+		t.Errorf("report =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLicenseID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		text, want string
+	}{
+		{`GNU LESSER GENERAL PUBLIC LICENSE
+		Version 2.1, February 1999
+		[This is the first released version of the Lesser GPL. It also counts
+		as the successor of the GNU Library Public License, version 2, hence
+		the version number 2.1.]
+		By contrast, the GNU General Public Licenses are intended to guarantee
+		your freedom to share and change free software`, "LGPL-2.1"},
+		{`GNU LESSER GENERAL PUBLIC LICENSE
+		Version 3, 29 June 2007
+		This version of the GNU Lesser General Public License incorporates
+		the terms and conditions of version 3 of the GNU General Public
+		License`, "LGPL-3.0"},
+		{`GNU LIBRARY GENERAL PUBLIC LICENSE
+		Version 2, June 1991
+		[This is the first released version of the library GPL. It is
+		numbered 2 because it goes with version 2 of the ordinary GPL.]`, "LGPL-2.0"},
+		{`GNU GENERAL PUBLIC LICENSE
+		Version 2, June 1991
+		the GNU General Public License is intended to guarantee your freedom`, "GPL-2.0"},
+		{`GNU GENERAL PUBLIC LICENSE
+		Version 3, 29 June 2007`, "GPL-3.0"},
+		{`GNU General Public License, version 20`, ""},
+	}
+	for _, c := range cases {
+		name := filepath.Join(dir, "COPYING")
+		if err := ioutil.WriteFile(name, []byte(c.text), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if got := licenseID(name); got != c.want {
+			t.Errorf("licenseID(%.40q) = %q want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestGitRevision(t *testing.T) {
+	const rev = "0123456789abcdef0123456789abcdef01234567"
+	cases := []struct {
+		tab, want string
+	}{
+		{`
+			d/d.go: package d
+		`, ""},
+		{`
+			d/d.go:        package d
+			d/.git/HEAD:   ` + rev + `
+		`, rev},
+		{`
+			d/d.go:        package d
+			d/.git/HEAD:   ref: refs/heads/master
+			d/.git/refs/heads/master: ` + rev + `
+		`, rev},
+		{`
+			d/d.go:        package d
+			d/.git/HEAD:   ref: refs/heads/master
+			d/.git/packed-refs: ` + rev + ` refs/heads/master
+		`, rev},
+		{`
+			d/sub/d.go:    package d
+			d/.git/HEAD:   ` + rev + `
+		`, rev},
+		{`
+			d/d.go:        package d
+			d/.git:        gitdir: ../x
+			x/HEAD:        ` + rev + `
+		`, rev},
+		{
+			// GOPATH, not d, is in a checkout.
+			`
+			d/d.go:        package d
+			../.git/HEAD:  ` + rev + `
+		`, ""},
+	}
+	for _, test := range cases {
+		r, clean := setup(t, "d", test.tab)
+		dir := filepath.Dir(filepath.Join(r.Context.GOPATH, "src", strings.Fields(test.tab)[0]))
+		if got := gitRevision(dir, filepath.Join(r.Context.GOPATH, "src")); got != test.want {
+			t.Errorf("gitRevision(%q) = %q want %q", dir, got, test.want)
+			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
+		}
+		clean()
+	}
+}
+
+func TestVerifyDeps(t *testing.T) {
+	cases := []struct {
+		tab  string
+		want bool
+	}{
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d
+			d/d.go:          package d
+		`, true},
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d
+			p/vendor/vexp.json: {}
+			p/vendor/.git/HEAD: ref: refs/heads/master
+			d/d.go:          package d
+		`, true},
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d // changed
+			d/d.go:          package d
+		`, false},
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d
+			d/d.go:          package d
+			d/x.go:          package d
+		`, false},
+		{`
+			p/p.go:          package p; import _ "d"
+			d/d.go:          package d
+		`, false},
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d
+			p/vendor/d/x.go: package d
+			d/d.go:          package d
+		`, false},
+		{`
+			p/p.go:          package p; import _ "d"
+			p/vendor/d/d.go: package d
+			p/vendor/e/e.go: package e
+			d/d.go:          package d
+		`, false},
+	}
+	for _, test := range cases {
+		r, clean := setup(t, "p", test.tab)
+		r.Update = []string{"..."}
+		r = mustResolver(t, r.Config)
+		deps := r.dependencies(r.packages([]string{"p"}))
+		if got := r.verifyDeps(deps); got != test.want {
+			t.Errorf("verifyDeps = %v want %v", got, test.want)
+			t.Log("in", strings.Replace(test.tab, "\t", "", -1))
+		}
+		clean()
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:           package p; import _ "d"
+		d/d.go:           package d
+		d/corpus/big.txt: x
+	`)
+	defer clean()
+	big := bytes.Repeat([]byte("x"), 1000)
+	if err := ioutil.WriteFile(filepath.Join(r.Context.GOPATH, "src", "d", "corpus", "big.txt"), big, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.Context.GOPATH, "src", "d", "d.go"), append([]byte("package d\n//"), big...), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	r.Stderr = &stderr
+	r.Verbosity = 1
+	r.MaxFileSize = 100
+	r = mustResolver(t, r.Config)
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	vendor := filepath.Join(r.Dir, "vendor", "d")
+	if _, err := os.Stat(filepath.Join(vendor, "d.go")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(vendor, "corpus", "big.txt")); !os.IsNotExist(err) {
+		t.Errorf("big.txt copied, err = %v", err)
+	}
+	if !strings.Contains(stderr.String(), "big.txt: larger than 100 bytes") {
+		t.Errorf("stderr = %q, want warning about big.txt", stderr.String())
+	}
+}
+
+func TestImportCycles(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d"
+		d/d.go:      package d; import _ "e"
+		e/e.go:      package e; import _ "f"; import _ "g"
+		f/f.go:      package f; import _ "d"
+		g/g.go:      package g
+		g/g_test.go: package g; import _ "p"
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	got := ImportCycles(roots)
+	want := [][]string{{"d", "e", "f"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportCycles = %v want %v", got, want)
+	}
+}
+
+func TestVendorGoroot(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "corp/x"
+	`)
+	defer clean()
+	goroot := filepath.Join(r.Context.GOPATH, "goroot")
+	x := filepath.Join(goroot, "src", "corp", "x")
+	if err := os.MkdirAll(x, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(x, "x.go"), []byte("package x\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	r.Context.GOROOT = goroot
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if len(deps) != 0 {
+		t.Errorf("dependencies = %v want none", names(deps))
+	}
+
+	r.Goroot = []string{"corp"}
+	r = mustResolver(t, r.Config)
+	deps = r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"corp/x"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if deps[0].Standard {
+		t.Error("corp/x is Standard")
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "corp", "x", "x.go")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInCWD(t *testing.T) {
+	defer func(fold bool) { foldPaths = fold }(foldPaths)
+	cwd := filepath.Join(os.TempDir(), "vexp-test", "p")
+	sep := string(filepath.Separator)
+	cases := []struct {
+		cwd, path string
+		fold      bool
+		want      bool
+	}{
+		{cwd, cwd, false, true},
+		{cwd, filepath.Join(cwd, "d"), false, true},
+		{cwd, cwd + "x", false, false},
+		{cwd + sep, cwd, false, true},
+		{cwd + sep, filepath.Join(cwd, "d"), false, true},
+		{cwd + sep, cwd + "x", false, false},
+		{cwd, cwd + sep + "d" + sep, false, true},
+		{cwd, filepath.Join(cwd, "..", "px"), false, false},
+		{cwd, strings.ToUpper(filepath.Join(cwd, "d")), false, false},
+		{cwd, strings.ToUpper(filepath.Join(cwd, "d")), true, true},
+		{strings.ToUpper(cwd) + sep, filepath.Join(cwd, "d"), true, true},
+		{cwd, strings.ToUpper(cwd + "x"), true, false},
+	}
+	for _, test := range cases {
+		foldPaths = test.fold
+		r := &Resolver{cwd: test.cwd, realPathCache: make(map[string]string)}
+		if got := r.inCWD(test.path); got != test.want {
+			t.Errorf("inCWD(%q) with cwd %q, fold %v = %v want %v", test.path, test.cwd, test.fold, got, test.want)
+		}
+	}
+}
+
+func TestReadLockfile(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:           package p
+		p/vexp.lock:      {"example.com/d": "../elsewhere/d", "example.com/e": "../elsewhere/e"}
+		elsewhere/d/d.go: package d; import _ "f"
+		elsewhere/e/e.go: package e
+	`)
+	defer clean()
+
+	deps, err := r.ReadLockfile(filepath.Join(r.Dir, "vexp.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(deps), []string{"example.com/d", "example.com/e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadLockfile = %v want %v", got, want)
+	}
+	if err := r.Copy(context.Background(), deps); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"d/d.go", "e/e.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "example.com", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	bad := filepath.Join(r.Dir, "bad.lock")
+	for _, body := range []string{
+		`{"example.com/x": "../elsewhere/x"}`,
+		`{"example.com/x": "../elsewhere"}`,
+	} {
+		if err := ioutil.WriteFile(bad, []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadLockfile(bad); err == nil {
+			t.Errorf("ReadLockfile(%s) = nil error, want error", body)
+		}
+	}
+}
+
+func TestImportComment(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:               package p; import _ "example.com/d"; import _ "e"
+		p/third_party/e/e.go: package e // import "example.com/e"
+		example.com/d/d.go:   package d // import "example.com/d"
+	`)
+	defer clean()
+	r.Stdout = ioutil.Discard
+	r.OutDir = "third_party"
+	r = mustResolver(t, r.Config)
+
+	roots, deps, err := r.Resolve(context.Background(), []string{"p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range append(roots, deps...) {
+		if p.Error != nil {
+			t.Errorf("%s: %v", p.ImportPath, p.Error)
+		}
+	}
+	if err := r.Copy(context.Background(), deps); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "third_party", "example.com", "d", "d.go")); err != nil {
+		t.Error(err)
+	}
+
+	// Imported by another path, it is an error.
+	r, clean = setup(t, "p", `
+		p/p.go: package p; import _ "d"
+		d/d.go: package d // import "example.com/d"
+	`)
+	defer clean()
+	deps = r.dependencies(r.packages([]string{"p"}))
+	if len(deps) != 1 || deps[0].Error == nil || !strings.Contains(deps[0].Error.Err, `expects import "example.com/d"`) {
+		t.Errorf("deps = %v, want error about import comment", names(deps))
+	}
+}
+
+func TestSummary(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"; import _ "f"; import _ "g"
+		p/vendor/e/e.go: package e // old
+		p/vendor/f/f.go: package f
+		p/vendor/g/g.go: package g
+		d/d.go:          package d
+		e/e.go:          package e
+		f/f.go:          package f
+	`)
+	defer clean()
+	r.Stdout = ioutil.Discard
+	r.Update = []string{"e", "f"}
+	r = mustResolver(t, r.Config)
+
+	ctx := context.Background()
+	roots, deps, err := r.Resolve(ctx, []string{"p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Copy(ctx, deps); err != nil {
+		t.Fatal(err)
+	}
+	// d is added, e updated, f already up to date,
+	// and g vendored and not matched by -u.
+	got := r.Summary(r.Vendored(roots))
+	want := Summary{Added: 1, Updated: 1, Unchanged: 2}
+	if got != want {
+		t.Errorf("Summary = %+v want %+v", got, want)
+	}
+	if s, want := got.String(), "1 added, 1 updated, 2 unchanged"; s != want {
+		t.Errorf("String = %q want %q", s, want)
+	}
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+		d/d.go: package d
+	`)
+	defer clean()
+	var stdout, stderr bytes.Buffer
+	r.Stdout, r.Stderr = &stdout, &stderr
+	r.Verbosity = 3
+	r.LogFormat = "json"
+	r = mustResolver(t, r.Config)
+
+	ctx := context.Background()
+	roots, deps, err := r.Resolve(ctx, []string{"p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Copy(ctx, deps); err != nil {
+		t.Fatal(err)
+	}
+	r.PrintSummary(r.Summary(r.Vendored(roots)))
+
+	events := func(b *bytes.Buffer) map[string]event {
+		m := make(map[string]event)
+		for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+			var e event
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			m[e.Event] = e
+		}
+		return m
+	}
+	out := events(&stdout)
+	want := event{
+		Event:      "root",
+		Level:      "info",
+		ImportPath: "p",
+		Dir:        filepath.Join(r.Context.GOPATH, "src", "p"),
+		Message:    "root p",
+	}
+	if got := out["root"]; got != want {
+		t.Errorf("root event = %+v want %+v", got, want)
+	}
+	want = event{
+		Event:      "add",
+		Level:      "info",
+		ImportPath: "d",
+		Dir:        filepath.Join(r.Context.GOPATH, "src", "d"),
+		Dest:       filepath.Join(r.Dir, "vendor", "d"),
+		Message:    "add d",
+	}
+	if got := out["add"]; got != want {
+		t.Errorf("add event = %+v want %+v", got, want)
+	}
+
+	errOut := events(&stderr)
+	want = event{
+		Event:      "copy",
+		Level:      "debug",
+		ImportPath: "d",
+		Dir:        filepath.Join(r.Context.GOPATH, "src", "d", "d.go"),
+		Dest:       filepath.Join(r.Dir, "vendor", "d", "d.go"),
+		Message:    "copy " + filepath.Join(r.Context.GOPATH, "src", "d", "d.go"),
+	}
+	if got := errOut["copy"]; got != want {
+		t.Errorf("copy event = %+v want %+v", got, want)
+	}
+	if got := errOut["summary"]; got.Level != "info" || got.Message != "vexp: 1 added, 0 updated, 0 unchanged" {
+		t.Errorf("summary event = %+v", got)
+	}
+
+	r.LogFormat = "xml"
+	if _, err := NewResolver(r.Config); err == nil {
+		t.Error("NewResolver accepted -log-format xml")
+	}
+}
+
+func TestMultipleMajors(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:            package p; import _ "foo"; import _ "bar"; import _ "foo/v3/sub"
+		p/vendor/foo/f.go: package foo
+		foo/v2/f.go:       package foo
+		foo/v3/sub/s.go:   package sub
+		foo/sub/s.go:      package sub
+		bar/b.go:          package bar; import _ "foo/v2"; import _ "foo/sub"; import _ "v2/x"
+		v2/x/x.go:         package x
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	if anyErr(append(roots, r.dependencies(roots)...)) {
+		t.Fatal("unexpected error")
+	}
+	got := r.MultipleMajors(roots)
+	want := []MajorConflict{
+		{Base: "foo", ImportPaths: []string{"foo", "foo/v2"}},
+		{Base: "foo/sub", ImportPaths: []string{"foo/sub", "foo/v3/sub"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MultipleMajors = %+v want %+v", got, want)
+	}
+}
+
+func TestShadows(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:            package p; import _ "d"
+		p/q/q.go:          package q
+		d/d.go:            package d; import _ "p/q"
+		d/vendor/p/q/q.go: package q // d's copy
+	`)
+	defer clean()
+	r.Nested = true
+	r = mustResolver(t, r.Config)
+
+	roots := r.packages([]string{"p", "p/q"})
+	deps := r.dependencies(roots)
+	src := filepath.Join(r.Context.GOPATH, "src")
+	want := []Conflict{{
+		ImportPath: "p/q",
+		Dirs:       []string{filepath.Join(src, "p", "q"), filepath.Join(src, "d", "vendor", "p", "q")},
+	}}
+	if got := r.Shadows(roots, deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("Shadows = %+v want %+v", got, want)
+	}
+
+	// Without -nested, d's import resolves to our own package.
+	r.Nested = false
+	r = mustResolver(t, r.Config)
+	roots = r.packages([]string{"p", "p/q"})
+	if got := r.Shadows(roots, r.dependencies(roots)); len(got) != 0 {
+		t.Errorf("Shadows = %+v want none", got)
+	}
+}
+
+func TestClean(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:              package p; import _ "d"
+		p/vendor/d/d.go:     package d
+		p/vendor/old/old.go: package old
+		d/d.go:              package d
+	`)
+	defer clean()
+	vendor := filepath.Join(r.Dir, "vendor")
+	unlock, err := r.Lock(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	r.DryRun = true
+	r.Stdout = ioutil.Discard
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(vendor, "old")); err != nil {
+		t.Errorf("after dry run: %v", err)
+	}
+	r.DryRun = false
+	r = mustResolver(t, r.Config)
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", LockName} {
+		if _, err := os.Stat(filepath.Join(vendor, filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(vendor, "old")); !os.IsNotExist(err) {
+		t.Errorf("stale package old survived, err = %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(vendor, "d", ".git"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Clean(); err == nil {
+		t.Error("Clean with .git = nil error, want error")
+	}
+	if _, err := os.Stat(filepath.Join(vendor, "d", "d.go")); err != nil {
+		t.Errorf("after refusing: %v", err)
+	}
+}
+
+func TestCleanOwnPackages(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:            package p; import _ "p/internal/a"
+		p/internal/a/a.go: package a; import _ "p/internal/b"
+		p/internal/b/b.go: package b
+	`)
+	defer clean()
+	r.OutDir = "internal"
+	r.Stdout = ioutil.Discard
+	r = mustResolver(t, r.Config)
+
+	err := r.Clean()
+	if err == nil || !strings.Contains(err.Error(), "imports p/internal/b") {
+		t.Errorf("Clean = %v, want error about p/internal/b", err)
+	}
+	for _, name := range []string{"a/a.go", "b/b.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "internal", filepath.FromSlash(name))); err != nil {
+			t.Errorf("after refusing: %v", err)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p
+	`)
+	defer clean()
+	ctx := context.Background()
+	vendor := filepath.Join(r.Dir, "vendor")
+	lock := filepath.Join(vendor, LockName)
+
+	unlock, err := r.Lock(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); err != nil {
+		t.Error(err)
+	}
+	// Another run can't get the lock while it's held.
+	if _, err := r.Lock(ctx, 0); err == nil || !strings.Contains(err.Error(), "another vexp run") {
+		t.Errorf("second Lock = %v, want error about another run", err)
+	}
+	unlock()
+	unlock()
+	if _, err := os.Stat(vendor); !os.IsNotExist(err) {
+		t.Errorf("vendor exists after unlock, err = %v", err)
+	}
+
+	// With a timeout, Lock waits for the lock to be released.
+	held, err := r.Lock(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, held)
+	unlock, err = r.Lock(ctx, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestCopyEmbed(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:                package p; import _ "d"
+		d/testdata/hello.txt:  hello
+		d/testdata/other.txt:  not embedded
+		d/_static/a.css:       body {}
+		d/_static/.hidden:     not embedded
+		d/_static/_b.css:      not embedded
+	`)
+	defer clean()
+	src := "package d\n\n" +
+		"import _ \"embed\"\n\n" +
+		"//go:embed testdata/hello.txt _static missing.txt\n" +
+		"var s string\n"
+	name := filepath.Join(r.Context.GOPATH, "src", "d", "d.go")
+	if err := ioutil.WriteFile(name, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	r.Stdout = ioutil.Discard
+	r.Stderr = &stderr
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	want := map[string]bool{
+		"d/d.go":               true,
+		"d/testdata/hello.txt": true,
+		"d/testdata/other.txt": false,
+		"d/_static/a.css":      true,
+		"d/_static/.hidden":    false,
+		"d/_static/_b.css":     false,
+	}
+	for name, want := range want {
+		_, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+		if want && err != nil {
+			t.Error(err)
+		} else if !want && !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+	if want := "warning: d: pattern missing.txt in //go:embed matches no files"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+	if !r.verifyDeps(deps) {
+		t.Error("verifyDeps failed")
+	}
+}
+
+func TestRequireVCS(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d/sub"; import _ "e"
+		d/.git/HEAD: ref: refs/heads/master
+		d/sub/s.go:  package sub
+		e/e.go:      package e
+	`)
+	defer clean()
+	r.RequireVCS = true
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d/sub", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if deps[0].Error != nil {
+		t.Errorf("d/sub: %v", deps[0].Error)
+	}
+	want := "package e in " + filepath.Join(r.Context.GOPATH, "src", "e") + " is not under version control"
+	if deps[1].Error == nil || !strings.Contains(deps[1].Error.Err, want) {
+		t.Errorf("e: error = %v, want %q", deps[1].Error, want)
+	}
+}
+
+func TestCopyGoMod(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "m/x/a"; import _ "m/x/b"; import _ "own"
+		m/.git/HEAD: ref: refs/heads/master
+		m/x/go.mod:  module m/x
+		m/x/go.sum:  m sums
+		m/x/a/a.go:  package a
+		m/x/b/b.go:  package b
+		own/go.mod:  module own
+		own/o.go:    package own
+	`)
+	defer clean()
+	r.CopyGoMod = true
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	want := map[string]string{
+		"m/x/a/go.mod": "module m/x\n",
+		"m/x/a/go.sum": "m sums\n",
+		"m/x/b/go.mod": "module m/x\n",
+		"m/x/b/go.sum": "m sums\n",
+		"own/go.mod":   "module own\n",
+	}
+	for name, want := range want {
+		b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != want {
+			t.Errorf("vendor/%s = %q want %q", name, b, want)
+		}
+	}
+	for _, name := range []string{"m/x/go.mod", "own/go.sum"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("vendor/%s copied, err = %v", name, err)
+		}
+	}
+	if !r.verifyDeps(deps) {
+		t.Error("verifyDeps failed")
+	}
+}
+
+func TestCopySwig(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		d/d.go:          package d
+		d/d.swig:        %module d
+		d/d_cxx.swigcxx: %module d
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Stderr = &stderr
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d.swig", "d_cxx.swigcxx"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if want := "warning: d uses SWIG"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestCopyUnderscore(t *testing.T) {
+	for _, underscore := range []bool{false, true} {
+		r, clean := setup(t, "p", `
+			p/p.go:           package p; import _ "d"
+			p/_old/old.go:    package old
+			d/d.go:           package d
+			d/_impl/impl.go:  package impl
+			d/testdata/x.txt: x
+		`)
+		r.Underscore = underscore
+		r = mustResolver(t, r.Config)
+		args, err := r.rootArgs([]string{"./..."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(r.packages(args)); !reflect.DeepEqual(got, []string{"p"}) {
+			t.Errorf("underscore %v: roots = %v want [p]", underscore, got)
+		}
+		if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+			t.Error("copyDeps failed")
+		}
+		_, err = os.Stat(filepath.Join(r.Dir, "vendor", "d", "_impl", "impl.go"))
+		if underscore && err != nil {
+			t.Error(err)
+		} else if !underscore && !os.IsNotExist(err) {
+			t.Errorf("_impl copied without -copy-underscore, err = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "testdata")); !os.IsNotExist(err) {
+			t.Errorf("underscore %v: testdata copied, err = %v", underscore, err)
+		}
+		if !r.verifyDeps(r.dependencies(r.packages([]string{"p"}))) {
+			t.Errorf("underscore %v: verifyDeps failed", underscore)
+		}
+		clean()
+	}
+}
+
+func TestJoinWithin(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "vendor", "d")
+	cases := []struct {
+		rel string
+		ok  bool
+	}{
+		{".", true},
+		{"d.go", true},
+		{filepath.Join("x", "..", "d.go"), true},
+		{"..", false},
+		{filepath.Join("..", "e", "e.go"), false},
+		{filepath.Join("x", "..", "..", "..", "evil"), false},
+	}
+	for _, test := range cases {
+		_, err := joinWithin(dir, test.rel)
+		if (err == nil) != test.ok {
+			t.Errorf("joinWithin(%q, %q) err = %v, want ok %v", dir, test.rel, err, test.ok)
+		}
+	}
+}
+
+func TestCopyEscape(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p
+		p/evil.lock: {"../../evil": "../d"}
+		d/d.go:      package d
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Stderr = &stderr
+
+	deps, err := r.ReadLockfile(filepath.Join(r.Dir, "evil.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Copy(context.Background(), deps); err == nil {
+		t.Error("Copy = nil, want error")
+	}
+	if _, err := os.Stat(filepath.Join(r.Context.GOPATH, "src", "evil")); !os.IsNotExist(err) {
+		t.Errorf("copied outside the vendor tree, err = %v", err)
+	}
+	if !strings.Contains(stderr.String(), "would be copied outside vendor") {
+		t.Errorf("stderr = %q, want error about copying outside vendor", stderr.String())
+	}
+}
+
+func TestMap(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:            package p; import _ "d/sub"; import _ "dd"
+		d/sub/sub.go:      package sub
+		dd/dd.go:          package dd
+		fork/d/sub/sub.go: package sub; import _ "e"
+		e/e.go:            package e
+	`)
+	defer clean()
+	fork := filepath.Join(r.Context.GOPATH, "src", "fork", "d")
+	m, err := ParseMap("d=" + fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Map = m
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d/sub", "dd", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if got, want := deps[0].Dir, filepath.Join(fork, "sub"); got != want {
+		t.Errorf("d/sub Dir = %s want %s", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "sub", "sub.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `import _ "e"`) {
+		t.Errorf("vendored d/sub = %q, want the fork's copy", b)
+	}
+
+	for _, s := range []string{"d", "=/x", "d="} {
+		if _, err := ParseMap(s); err == nil {
+			t.Errorf("ParseMap(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	// The snapshot, in ../snap, is outside GOPATH.
+	r, clean := setup(t, "p", `
+		p/p.go:               package p; import _ "d"; import _ "dd"; import _ "p/sub"
+		p/sub/sub.go:         package sub
+		dd/dd.go:             package dd
+		../snap/d/d.go:       package d; import _ "e"
+		../snap/dd/dd.go:     package dd // snapshot
+		../snap/p/sub/sub.go: package sub; import _ "nonexistent"
+		e/e.go:               package e
+	`)
+	defer clean()
+	snap := filepath.Join(r.Context.GOPATH, "snap")
+	r.Snapshot = snap
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if anyErr(deps) {
+		t.Fatal("dependencies have errors")
+	}
+	if got, want := names(deps), []string{"d", "dd", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	for _, p := range deps[:2] {
+		if want := filepath.Join(snap, p.ImportPath); p.Dir != want {
+			t.Errorf("%s Dir = %s want %s", p.ImportPath, p.Dir, want)
+		}
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "dd", "dd.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "snapshot") {
+		t.Errorf("vendored dd = %q, want the snapshot's copy", b)
+	}
+}
+
+func TestGitIgnored(t *testing.T) {
+	r, clean := setup(t, "p/cmd", `
+		p/cmd/main.go: package main
+	`)
+	defer clean()
+	repo := filepath.Dir(r.Dir)
+	if file, pat := r.GitIgnored(); file != "" {
+		t.Errorf("GitIgnored outside a repository = %s, %s want none", file, pat)
+	}
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	ignore := filepath.Join(repo, ".gitignore")
+	tests := []struct {
+		gitignore string
+		pattern   string
+	}{
+		{"*.o\n", ""},
+		{"vendor/\n", "vendor/"},
+		{"/cmd/vendor\n", "/cmd/vendor"},
+		{"/vendor\n", ""},
+		{"**/vendor\n", "**/vendor"},
+		{"cmd/\n", "cmd/"},
+		{"vendor/\n!vendor/\n", ""},
+		{"cmd/\n!vendor/\n", "cmd/"},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(ignore, []byte(test.gitignore), 0666); err != nil {
+			t.Fatal(err)
+		}
+		file, pat := r.GitIgnored()
+		if pat != test.pattern || (pat != "" && file != ignore) {
+			t.Errorf("GitIgnored with %q = %q, %q want %q", test.gitignore, file, pat, test.pattern)
+		}
+	}
+}
+
+func TestUnbuildable(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d"; import _ "e"
+		d/d.go:      package d
+		e/e_test.go: package e
+		e/README:    e has only tests
+	`)
+	defer clean()
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(Unbuildable(deps)), []string{"e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unbuildable = %v want %v", got, want)
+	}
+}
+
+func TestCopySiblingPrefix(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "a/b"; import _ "a/bc"; import _ "a/b/c"
+		a/b/b.go:   package b
+		a/b/c/c.go: package c
+		a/bc/bc.go: package bc
+	`)
+	defer clean()
+	r.Stdout = ioutil.Discard
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(r.copyList(deps)), []string{"a/b", "a/bc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("copyList = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"a/b/b.go", "a/b/c/c.go", "a/bc/bc.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	for _, prune := range []bool{false, true} {
+		r, clean := setup(t, "p", `
+			p/p.go:             package p; import _ "d"; import _ "d/used"; import _ "d/unused/deep"
+			d/d.go:             package d
+			d/templates/t.html: <html>
+			d/used/u.go:        package used
+			d/unused/x.go:      package unused
+			d/unused/x.txt:     unused data
+			d/unused/deep/d.go: package deep
+			d/cmd/tool/main.go: package main
+		`)
+		r.Prune = prune
+		r = mustResolver(t, r.Config)
+		deps := r.dependencies(r.packages([]string{"p"}))
+		if !r.copyDeps(deps) {
+			t.Error("copyDeps failed")
+		}
+		want := map[string]bool{
+			"d/d.go":             true,
+			"d/templates/t.html": true,
+			"d/used/u.go":        true,
+			"d/unused/x.go":      !prune,
+			"d/unused/x.txt":     !prune,
+			"d/unused/deep/d.go": true,
+			"d/cmd/tool/main.go": !prune,
+		}
+		for name, want := range want {
+			_, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+			if want && err != nil {
+				t.Errorf("prune %v: %v", prune, err)
+			} else if !want && !os.IsNotExist(err) {
+				t.Errorf("prune %v: %s copied, err = %v", prune, name, err)
+			}
+		}
+		if !r.verifyDeps(deps) {
+			t.Errorf("prune %v: verifyDeps failed", prune)
+		}
+		clean()
+	}
+}
+
+func TestCopyNoTest(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d"
+		p/p_test.go: package p; import _ "e"
+		d/d.go:      package d
+		d/d_test.go: package d; import _ "f"
+		e/e.go:      package e
+		f/f.go:      package f
+	`)
+	defer clean()
+	r.NoTest = true
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Error("copyDeps failed")
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "d.go")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "d_test.go")); !os.IsNotExist(err) {
+		t.Errorf("d_test.go copied, err = %v", err)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	cases := []struct {
+		strip []string
+		want  []string
+	}{
+		{nil, []string{"d.go", "d_test.go", "doc.go", "example_test.go"}},
+		{[]string{"examples"}, []string{"d.go", "d_test.go", "doc.go"}},
+		{[]string{"tests"}, []string{"d.go", "doc.go"}},
+		{[]string{"docs"}, []string{"d.go", "d_test.go", "example_test.go"}},
+		{[]string{"examples", "docs"}, []string{"d.go", "d_test.go"}},
+	}
+	for _, test := range cases {
+		r, clean := setup(t, "p", `
+			p/p.go:            package p; import _ "d"
+			d/d.go:            package d
+			d/d_test.go:       package d
+			d/doc.go:          package d
+			d/example_test.go: package d_test
+		`)
+		r.Strip = test.strip
+		r = mustResolver(t, r.Config)
+		if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+			t.Error("copyDeps failed")
+		}
+		fis, err := ioutil.ReadDir(filepath.Join(r.Dir, "vendor", "d"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, fi := range fis {
+			got = append(got, fi.Name())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("strip %v: copied %v want %v", test.strip, got, test.want)
+		}
+		clean()
+	}
+
+	c := New(".")
+	c.Strip = []string{"images"}
+	if _, err := NewResolver(c); err == nil {
+		t.Error("NewResolver with -strip=images: want error")
+	}
+}
+
+func TestSetPlatform(t *testing.T) {
+	tab := `
+		p/p_darwin.go: package p; import _ "d"
+		p/p_linux.go:  package p; import _ "e"
+		d/d.go: package d
+		e/e.go: package e
+	`
+	for _, goos := range []string{"darwin", "linux"} {
+		r, clean := setup(t, "p", tab)
+		SetPlatform(&r.Context, goos, "amd64")
+		r = mustResolver(t, r.Config)
+		got := names(r.dependencies(r.packages([]string{"p"})))
+		want := map[string][]string{"darwin": {"d"}, "linux": {"e"}}[goos]
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GOOS=%s dependencies = %v want %v", goos, got, want)
+		}
+		clean()
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	for _, tags := range [][]string{nil, {"purego"}} {
+		r, clean := setup(t, "p", `
+			p/p.go: package p; import _ "d"
+			d/d.go: package d
+			e/e.go: package e
+		`)
+		tagged := filepath.Join(r.Dir, "p_purego.go")
+		if err := ioutil.WriteFile(tagged, []byte("// +build purego\n\npackage p\n\nimport _ \"e\"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		SetPlatform(&r.Context, "linux", "amd64")
+		r.Context.BuildTags = tags
+		r = mustResolver(t, r.Config)
+		got := names(r.dependencies(r.packages([]string{"p"})))
+		want := []string{"d"}
+		if tags != nil {
+			want = []string{"d", "e"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tags %v: dependencies = %v want %v", tags, got, want)
+		}
+		clean()
+	}
+}
+
+func TestSetPlatformFiles(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:         package p; import _ "d"
+		d/d.go:         package d
+		d/d_linux.go:   package d
+		d/d_darwin.go:  package d
+		d/d_test.go:    package d
+		d/README:       hello
+	`)
+	defer clean()
+	custom := filepath.Join(r.Context.GOPATH, "src", "d", "d_custom.go")
+	if err := ioutil.WriteFile(custom, []byte("// +build custom\n\npackage d\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	SetPlatform(&r.Context, "linux", "amd64")
+	r = mustResolver(t, r.Config)
+	if !r.copyDeps(r.dependencies(r.loadRoots([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(r.Dir, "vendor", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fi.Name())
+	}
+	want := []string{"README", "d.go", "d_linux.go", "d_test.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("vendor/d = %v want %v", got, want)
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p_darwin.go:  package p; import _ "d"
+		p/p_linux.go:   package p; import _ "d"; import _ "e"
+		p/p_windows.go: package p; import _ "f"
+		d/d_darwin.go:  package d
+		d/d_linux.go:   package d
+		d/d_windows.go: package d
+		d/README:       hello
+		e/e.go:         package e
+		f/f.go:         package f
+	`)
+	defer clean()
+	var err error
+	r.Platforms, err = ParsePlatforms("linux/amd64,darwin/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := r.loadRoots([]string{"p"})
+	if !r.ctxt.UseAllFiles {
+		t.Errorf("build context not restored")
+	}
+	deps := r.dependencies(roots)
+	if got, want := names(deps), []string{"d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Error("copyDeps failed")
+	}
+	for _, name := range []string{"d_darwin.go", "d_linux.go", "README", "../e/e.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"d_windows.go", "../f"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", name)); !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+}
+
+func TestFlagPlatforms(t *testing.T) {
+	got, err := ParsePlatforms("linux/amd64, darwin/arm64")
+	want := []Platform{{"linux", "amd64"}, {"darwin", "arm64"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePlatforms = %v, %v want %v", got, err, want)
+	}
+	for _, s := range []string{"linux", "linux/", "/amd64", "a/b/c"} {
+		if _, err := ParsePlatforms(s); err == nil {
+			t.Errorf("ParsePlatforms(%q) err = nil, want error", s)
+		}
+	}
+}
+
+func TestCopyDepsParallel(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "a"; import _ "b"; import _ "c"; import _ "d"
+		a/a.go:     package a; import _ "a/x"
+		a/x/x.go:   package x
+		b/b.go:     package b
+		c/r.go:     package c
+		d/d.go:     package d
+		d/sub/f.go: package sub
+	`)
+	defer clean()
+	r.Jobs = 3
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Error("copyDeps failed")
+	}
+	for _, name := range []string{"a/a.go", "a/x/x.go", "b/b.go", "c/r.go", "d/d.go", "d/sub/f.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCopyFileLink(t *testing.T) {
+	r, clean := setup(t, "d", `
+		d/d.go: package d
+	`)
+	defer clean()
+	r.Link = true
+	src := filepath.Join(r.Dir, "d.go")
+
+	dst := filepath.Join(r.Dir, "linked.go")
+	if err := r.copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	sfi, _ := os.Stat(src)
+	dfi, _ := os.Stat(dst)
+	if !os.SameFile(sfi, dfi) {
+		t.Errorf("%s is not a link to %s", dst, src)
+	}
+
+	// Force the fallback, as if across devices.
+	defer func() { osLink = os.Link }()
+	osLink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	dst = filepath.Join(r.Dir, "copied.go")
+	if err := r.copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	dfi, _ = os.Stat(dst)
+	if os.SameFile(sfi, dfi) {
+		t.Errorf("%s is a link to %s", dst, src)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "package d\n" {
+		t.Errorf("%s = %q want %q", dst, b, "package d\n")
+	}
+}
+
+func TestCopyFileReflink(t *testing.T) {
+	r, clean := setup(t, "d", `
+		d/d.go: package d
+	`)
+	defer clean()
+	r.Reflink = true
+	src := filepath.Join(r.Dir, "d.go")
+	mtime := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whether or not this file system can clone files,
+	// the copy must come out the same.
+	check := func(dst string) {
+		t.Helper()
+		if b, _ := ioutil.ReadFile(dst); string(b) != "package d\n" {
+			t.Errorf("%s = %q want %q", dst, b, "package d\n")
+		}
+		if fi, err := os.Stat(dst); err != nil {
+			t.Error(err)
+		} else if !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s mod time = %v want %v", dst, fi.ModTime(), mtime)
+		}
+	}
+	dst := filepath.Join(r.Dir, "cloned.go")
+	if err := r.copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	check(dst)
+
+	// Force the fallback, as if the file system can't.
+	defer func() { osReflink = reflink }()
+	osReflink = func(dst, src string) error {
+		return &os.PathError{Op: "reflink", Path: dst, Err: syscall.EOPNOTSUPP}
+	}
+	dst = filepath.Join(r.Dir, "copied.go")
+	if err := r.copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	check(dst)
+}
+
+func TestFsync(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:        package p; import _ "d"
+		d/d.go:        package d
+		d/sub/doc.txt: docs
+	`)
+	defer clean()
+	r.Fsync = true
+	r = mustResolver(t, r.Config)
+
+	var mu sync.Mutex
+	synced := make(map[string]bool)
+	defer func() { osSync = (*os.File).Sync }()
+	osSync = func(f *os.File) error {
+		mu.Lock()
+		defer mu.Unlock()
+		synced[f.Name()] = true
+		synced[filepath.Base(f.Name())] = true
+		return f.Sync()
+	}
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d.go", "doc.txt", "sub", filepath.Join(r.Dir, "vendor")} {
+		if !synced[name] {
+			t.Errorf("%s not synced", name)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"; import _ "e"; import _ "f"
+		d/d.go: package d
+		e/e.go: package e
+		f/f.go: package f
+	`)
+	defer clean()
+	r.Progress = true
+	deps := r.dependencies(r.packages([]string{"p"}))
+
+	defer func(d time.Duration) { progressInterval = d }(progressInterval)
+	tests := []struct {
+		interval time.Duration
+		want     string
+	}{
+		{0, "copied 1/3 packages\ncopied 2/3 packages\ncopied 3/3 packages\n"},
+		{time.Hour, "copied 3/3 packages\n"},
+	}
+	for _, test := range tests {
+		progressInterval = test.interval
+		var buf bytes.Buffer
+		r.Stderr = &buf
+		r = mustResolver(t, r.Config)
+		if !r.copyDeps(deps) {
+			t.Fatal("copyDeps failed")
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("with interval %v, progress = %q want %q", test.interval, got, test.want)
+		}
+	}
+}
+
+// TestProgressWarnings checks that progress lines and the
+// warnings printed while copying come out in order, with
+// copies running at once; run it with -race too.
+func TestProgressWarnings(t *testing.T) {
+	const n = 40
+	tab := "p/p.go: package p"
+	for i := 0; i < n; i++ {
+		tab += fmt.Sprintf("; import _ \"d%02d\"", i)
+	}
+	for i := 0; i < n; i++ {
+		tab += fmt.Sprintf("\nd%02d/d.go: package d%02d", i, i)
+	}
+	r, clean := setup(t, "p", tab)
+	defer clean()
+	var buf bytes.Buffer
+	r.Stderr = &buf
+	r.Progress = true
+	r.Licenses = true
+	r.Jobs = 8
+	r = mustResolver(t, r.Config)
+
+	defer func(d time.Duration) { progressInterval = d }(progressInterval)
+	progressInterval = 0
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	var want string
+	for i := 0; i < n; i++ {
+		want += fmt.Sprintf("warning: no license found for d%02d\ncopied %d/%d packages\n", i, i+1, n)
+	}
+	want += fmt.Sprintf("warning: %d package(s) with no license\n", n)
+	if got := buf.String(); got != want {
+		t.Errorf("stderr = %q want %q", got, want)
+	}
+}
+
+func TestNormalizeEOL(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+	`)
+	defer clean()
+	r.NormalizeEOL = true
+	r = mustResolver(t, r.Config)
+	src := filepath.Join(r.Context.GOPATH, "src", "d")
+	files := map[string]string{
+		"d.go":   "package d\r\n\r\nvar x = 1\r\n",
+		"d.syso": "\x00\r\n\x01",
+	}
+	if err := os.MkdirAll(src, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	want := map[string]string{
+		"d.go":   "package d\n\nvar x = 1\n",
+		"d.syso": "\x00\r\n\x01",
+	}
+	for name, body := range want {
+		b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != body {
+			t.Errorf("vendor/d/%s = %q want %q", name, b, body)
+		}
+	}
+	if !r.verifyDeps(deps) {
+		t.Error("verifyDeps = false after copy")
+	}
+}
+
+func TestCopyCaseCollision(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		p/vendor/d/d.go: package d // old
+		d/d.go:          package d // new
+		d/README:        upper
+		d/readme:        lower
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Update = []string{"d"}
+	r.Stderr = &stderr
+	r = mustResolver(t, r.Config)
+
+	if r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Error("copyDeps succeeded, want failure")
+	}
+	want := "case-insensitive file name collision: vendor/d/README and vendor/d/readme"
+	if got := stderr.String(); !strings.Contains(got, want) {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "d.go"))
+	if got, want := string(b), "package d // old\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+}
+
+func TestCopyOrder(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d"
+		d/d.go:      package d
+		d/c.txt:     c
+		d/a/z.txt:   z
+		d/a-b/x.txt: x
+		d/a.txt:     a
+		d/-x.txt:    x
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Verbosity = levelFiles
+	r.Stdout = ioutil.Discard
+	r.Stderr = &stderr
+	r = mustResolver(t, r.Config)
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	var got []string
+	prefix := "copy " + filepath.Join(r.Context.GOPATH, "src", "d") + string(filepath.Separator)
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			got = append(got, filepath.ToSlash(line[len(prefix):]))
+		}
+	}
+	want := []string{"-x.txt", "a-b/x.txt", "a.txt", "a/z.txt", "c.txt", "d.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copied %q want %q", got, want)
+	}
+}
+
+func TestVendorIsFile(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:   package p; import _ "d"
+		p/vendor: not a directory
+		d/d.go:   package d
+	`)
+	defer clean()
+	_, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Copy(context.Background(), deps)
+	want := "vendor is not a directory; remove it"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Copy = %v, want error containing %q", err, want)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(r.Dir, "vendor")); string(b) != "not a directory\n" {
+		t.Errorf("vendor = %q, want it untouched", b)
+	}
+}
+
+func TestExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "d"; import _ "e"
+		d/d.go:     package d; import _ "d/sub"
+		d/sub/s.go: package sub
+		e/e.go:     package e
+	`)
+	defer clean()
+	log := filepath.Join(r.Dir, "hooks.log")
+	r.Exec = "echo $VEXP_IMPORTPATH $VEXP_DIR >>" + log
+	r = mustResolver(t, r.Config)
+	_, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Copy(context.Background(), deps); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(log)
+	vendor := filepath.Join(r.Dir, "vendor")
+	want := "d " + filepath.Join(vendor, "d") + "\ne " + filepath.Join(vendor, "e") + "\n"
+	if string(b) != want {
+		t.Errorf("hooks ran as %q want %q", b, want)
+	}
+
+	r.Exec = "test $VEXP_IMPORTPATH != e"
+	r.Update = []string{"all"}
+	r = mustResolver(t, r.Config)
+	_, deps, _ = r.Resolve(context.Background(), []string{"."})
+	err = r.Copy(context.Background(), deps)
+	if err == nil || !strings.HasPrefix(err.Error(), "-exec for e: ") {
+		t.Errorf("Copy = %v, want -exec error for e", err)
+	}
+}
+
+func TestExecCgoIncludes(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	r, clean := setup(t, "p", `
+		p/p.go:        package p; import _ "o"
+		elsewhere/e.h: int e;
+	`)
+	defer clean()
+	// The #cgo line must be on a line of its own.
+	name := filepath.Join(r.Context.GOPATH, "src", "o", "o.go")
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte("package o\n\n// #cgo CPPFLAGS: -I../elsewhere\nimport \"C\"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(r.Dir, "hooks.log")
+	r.Exec = "echo $VEXP_IMPORTPATH >>" + log
+	r = mustResolver(t, r.Config)
+	_, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := capture(t, &os.Stderr, func() { err = r.Copy(context.Background(), deps) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(got, "cgo include directory"); n != 1 {
+		t.Errorf("stderr = %q, want one warning", got)
+	}
+	if b, _ := ioutil.ReadFile(log); string(b) != "o\n" {
+		t.Errorf("hooks ran as %q want %q", b, "o\n")
+	}
+}
+
+func TestCopyCanceled(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		p/vendor/d/d.go: package d // old
+		d/d.go:          package d // new
+		d/d2.go:         package d
+		e/e.go:          package e
+	`)
+	defer clean()
+	var stderr bytes.Buffer
+	r.Update = []string{"d"}
+	r.Jobs = 1
+	r.Link = true
+	r.Stderr = &stderr
+	r = mustResolver(t, r.Config)
+	_, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel while copying the first file.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := 0
+	defer func() { osLink = os.Link }()
+	osLink = func(oldname, newname string) error {
+		files++
+		cancel()
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	err = r.Copy(ctx, deps)
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("Copy = %v, want canceled error", err)
+	}
+	if files != 1 {
+		t.Errorf("started copying %d files, want 1", files)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("stderr = %q, want nothing", stderr.String())
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "d.go"))
+	if got, want := string(b), "package d // old\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "e")); !os.IsNotExist(err) {
+		t.Errorf("vendor/e copied, err = %v", err)
+	}
+}
+
+func TestUpdateAll(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		p/vendor/d/d.go: package d; import _ "e" // old
+		p/vendor/e/e.go: package e // old
+		d/d.go:          package d; import _ "e" // new
+		e/e.go:          package e // new
+	`)
+	defer clean()
+	r.Update = []string{"all"}
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "e/e.go"} {
+		b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if !strings.HasSuffix(string(b), "// new\n") {
+			t.Errorf("vendor/%s = %q, want new copy", name, b)
+		}
+	}
+}
+
+func TestIgnoreVendorErrors(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:              package p; import _ "d"; import _ "e"
+		p/vendor/d/a.go:     package d
+		p/vendor/d/b.go:     package x
+		p/vendor/e/README:   half copied
+		d/a.go:              package d
+		d/b.go:              package d
+		e/e.go:              package e
+	`)
+	defer clean()
+	r.Stdout = ioutil.Discard
+	roots, _, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || len(roots[0].deps) != 2 {
+		t.Fatalf("roots = %v", roots)
+	}
+	for _, d := range roots[0].deps {
+		if d.Error == nil {
+			t.Errorf("broken vendored copy %s loaded", d.ImportPath)
+		}
+	}
+
+	var stderr bytes.Buffer
+	r.Stderr = &stderr
+	r.IgnoreVendorErrors = true
+	r = mustResolver(t, r.Config)
+	roots, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range append(roots, deps...) {
+		if p.Error != nil {
+			t.Errorf("package %s: %v", p.ImportPath, p.Error)
+		}
+	}
+	if got, want := names(deps), []string{"d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	for _, path := range []string{"d", "e"} {
+		if want := "warning: ignoring error in vendored copy of " + path + ":"; !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr = %q, want %q", stderr.String(), want)
+		}
+	}
+	if err := r.Copy(context.Background(), deps); err != nil {
+		t.Fatal(err)
+	}
+
+	// A normal run now finds the vendored copies whole.
+	r.IgnoreVendorErrors = false
+	r = mustResolver(t, r.Config)
+	roots, deps, err = r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(Loaded(roots)) != 1 || len(deps) != 0 {
+		t.Errorf("after copying: roots = %v, deps = %v", roots, names(deps))
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "b.go")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateNegated(t *testing.T) {
+	for _, update := range [][]string{
+		{"foo/...", "!foo/legacy"},
+		{"!foo/legacy", "foo/..."}, // order doesn't matter
+	} {
+		r, clean := setup(t, "p", `
+			p/p.go:                       package p; import _ "foo/a"; import _ "foo/legacy"; import _ "foo/legacy/sub"
+			p/vendor/foo/a/a.go:          package a // old
+			p/vendor/foo/legacy/l.go:     package legacy // old
+			p/vendor/foo/legacy/sub/s.go: package sub // old
+			foo/a/a.go:                   package a // new
+			foo/legacy/l.go:              package legacy // new
+			foo/legacy/sub/s.go:          package sub // new
+		`)
+		r.Update = update
+		r = mustResolver(t, r.Config)
+		deps := r.dependencies(r.packages([]string{"p"}))
+		if got, want := names(deps), []string{"foo/a", "foo/legacy/sub"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("-u %v: dependencies = %v want %v", update, got, want)
+		}
+		if got := r.UnmatchedUpdates(); len(got) != 0 {
+			t.Errorf("-u %v: UnmatchedUpdates = %v", update, got)
+		}
+		clean()
+	}
+}
+
+func TestUnmatchedUpdates(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		p/vendor/d/d.go: package d; import _ "d/e"
+		d/d.go:          package d; import _ "d/e"
+		d/e/e.go:        package e
+	`)
+	defer clean()
+	r.Update = []string{"d", "d/...", "dd", "x/..."}
+	r = mustResolver(t, r.Config)
+	if _, _, err := r.Resolve(context.Background(), []string{"."}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.UnmatchedUpdates(), []string{"dd", "x/..."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmatchedUpdates = %v want %v", got, want)
+	}
+}
+
+func TestCopyDepUpToDate(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "d"
+		d/d.go:     package d
+		d/sub/s.go: package sub
+	`)
+	defer clean()
+	r.Update = []string{"d"}
+	r = mustResolver(t, r.Config)
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	dst := filepath.Join(r.Dir, "vendor", "d")
+	fi1, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged: the old copy stays where it is.
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	if fi2, err := os.Stat(dst); err != nil || !os.SameFile(fi1, fi2) {
+		t.Errorf("up-to-date copy of d replaced, err = %v", err)
+	}
+
+	// An extra file in the vendored copy makes it out of date.
+	extra := filepath.Join(dst, "sub", "extra.go")
+	if err := ioutil.WriteFile(extra, []byte("package sub\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Errorf("extra file kept, err = %v", err)
+	}
+
+	// So does a change to the source.
+	src := filepath.Join(r.Context.GOPATH, "src", "d", "d.go")
+	if err := ioutil.WriteFile(src, []byte("package d // new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dst, "d.go"))
+	if got, want := string(b), "package d // new\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+}
+
+func TestCopyDepReadOnly(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "d"
+		d/d.go:     package d // old
+		d/sub/s.go: package sub
+	`)
+	defer clean()
+	r.Update = []string{"d"}
+	r = mustResolver(t, r.Config)
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	dst := filepath.Join(r.Dir, "vendor", "d")
+	for _, name := range []string{"d.go", "sub/s.go", "sub"} {
+		mode := os.FileMode(0444)
+		if name == "sub" {
+			mode = 0555
+		}
+		if err := os.Chmod(filepath.Join(dst, filepath.FromSlash(name)), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Chmod(filepath.Join(dst, "sub"), 0755) // for clean
+
+	src := filepath.Join(r.Context.GOPATH, "src", "d", "d.go")
+	if err := ioutil.WriteFile(src, []byte("package d // new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dst, "d.go"))
+	if got, want := string(b), "package d // new\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+	fis, _ := ioutil.ReadDir(filepath.Join(r.Dir, "vendor"))
+	if len(fis) != 1 {
+		t.Errorf("vendor has %d entries, want 1 (old copy left behind?)", len(fis))
+	}
+}
+
+func TestSince(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		p/vendor/d/d.go: package d // old
+		p/vendor/e/e.go: package e // old
+		d/d.go:          package d // new
+		e/e.go:          package e // new
+	`)
+	defer clean()
+	since := time.Now().Add(-time.Hour)
+	before, after := since.Add(-time.Hour), since.Add(time.Minute)
+	src := filepath.Join(r.Context.GOPATH, "src")
+	os.Chtimes(filepath.Join(src, "d", "d.go"), before, before)
+	os.Chtimes(filepath.Join(r.Dir, "vendor", "d", "d.go"), before, before)
+	os.Chtimes(filepath.Join(src, "e", "e.go"), after, after)
+	r.Since = since
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for name, want := range map[string]string{"d/d.go": "package d // old\n", "e/e.go": "package e // new\n"} {
+		b, _ := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+		if string(b) != want {
+			t.Errorf("vendor/%s = %q want %q", name, b, want)
+		}
+	}
+
+	newest, err := r.NewestModTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newest.Equal(after) {
+		t.Errorf("NewestModTime = %v want %v", newest, after)
+	}
+}
+
+func TestNewestModTime(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:              package p
+		p/gopath/src/d/d.go: package d
+		p/gopath/notes:      not vendored
+	`)
+	defer clean()
+	old, now := time.Now().Add(-time.Hour), time.Now()
+	os.Chtimes(filepath.Join(r.Dir, "gopath", "src", "d", "d.go"), old, old)
+	os.Chtimes(filepath.Join(r.Dir, "gopath", "notes"), now, now)
+	r.OutDir = filepath.Join(r.Dir, "gopath")
+	r.GopathLayout = true
+	r = mustResolver(t, r.Config)
+
+	newest, err := r.NewestModTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newest.Equal(old) {
+		t.Errorf("NewestModTime = %v want %v", newest, old)
+	}
+}
+
+func TestCopyDepKeepsOldOnError(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		p/vendor/d/d.go: package d // old
+		d/d.go:          package d // new
+	`)
+	defer clean()
+	r.Update = []string{"d"}
+	r = mustResolver(t, r.Config)
+	// A dangling symlink can't be copied.
+	src := filepath.Join(r.Context.GOPATH, "src")
+	if err := os.Symlink("nonexistent", filepath.Join(src, "d", "e.go")); err != nil {
+		t.Skip(err)
+	}
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if r.copyDeps(deps) {
+		t.Error("copyDeps succeeded, want failure")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "d.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "package d // old\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(r.Dir, "vendor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		var a []string
+		for _, fi := range fis {
+			a = append(a, fi.Name())
+		}
+		t.Errorf("vendor contains %v, want [d]", a)
+	}
+
+	os.Remove(filepath.Join(src, "d", "e.go"))
+	if !r.copyDeps(deps) {
+		t.Error("copyDeps failed")
+	}
+	b, _ = ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "d.go"))
+	if got, want := string(b), "package d // new\n"; got != want {
+		t.Errorf("vendor/d/d.go = %q want %q", got, want)
+	}
+}
+
+func TestCopyDepKeepsUnchangedFiles(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+		d/d.go: package d
+		d/e.go: package d
+	`)
+	defer clean()
+	r.Update = []string{"d"}
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	then := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"d.go", "e.go"} {
+		if err := os.Chtimes(filepath.Join(r.Dir, "vendor", "d", name), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(r.Context.GOPATH, "src")
+	if err := ioutil.WriteFile(filepath.Join(src, "d", "e.go"), []byte("package d // changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	fi, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "d.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(then) {
+		t.Errorf("unchanged d.go mtime = %v want %v", fi.ModTime(), then)
+	}
+	fi, err = os.Stat(filepath.Join(r.Dir, "vendor", "d", "e.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ModTime().Equal(then) {
+		t.Errorf("changed e.go mtime = %v, want new", fi.ModTime())
+	}
+}
+
+func TestCopyDepModTime(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:     package p; import _ "d"
+		d/d.go:     package d
+		d/sub/x.go: package sub
+	`)
+	defer clean()
+	then := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	src := filepath.Join(r.Context.GOPATH, "src")
+	for _, name := range []string{"d/d.go", "d/sub/x.go", "d/sub", "d"} {
+		if err := os.Chtimes(filepath.Join(src, filepath.FromSlash(name)), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "d/sub/x.go", "d/sub", "d"} {
+		fi, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if d := fi.ModTime().Sub(then); d < -time.Second || d > time.Second {
+			t.Errorf("%s mtime = %v want %v", name, fi.ModTime(), then)
+		}
+	}
+}
+
+func TestRootArgs(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p
+		p/cmd/a/a.go:    package main
+		p/cmd/b/b.go:    package main
+		p/cmd/_x/x.go:   package main
+		p/internal/i.go: package internal
+		q/q.go:          package q
+	`)
+	defer clean()
+	cases := []struct {
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{[]string{"./..."}, []string{"./.", "./cmd/a", "./cmd/b", "./internal"}, false},
+		{[]string{"./cmd/..."}, []string{"./cmd/a", "./cmd/b"}, false},
+		{[]string{"./cmd/a", "./internal"}, []string{"./cmd/a", "./internal"}, false},
+		{[]string{"p/cmd/..."}, []string{"./cmd/a", "./cmd/b"}, false},
+		{[]string{"p/cmd/a"}, []string{"./cmd/a"}, false},
+		{[]string{"./nothing/..."}, nil, false},
+		{[]string{"../q"}, nil, true},
+		{[]string{"../..."}, nil, true},
+		{[]string{"q"}, nil, true},
+		{[]string{"pq"}, nil, true},
+	}
+	for _, test := range cases {
+		got, err := r.rootArgs(test.patterns)
+		if (err != nil) != test.wantErr {
+			t.Errorf("rootArgs(%q) err = %v want error %v", test.patterns, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rootArgs(%q) = %q want %q", test.patterns, got, test.want)
+		}
+	}
+}
+
+func TestUnmatchedPatterns(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:       package p
+		p/cmd/a/a.go: package main
+	`)
+	defer clean()
+	r.Stdout = ioutil.Discard
+	roots, _, err := r.Resolve(context.Background(), []string{"./cmd/...", "./tools/...", "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(roots), []string{"p/cmd/a", "p"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("roots = %v want %v", got, want)
+	}
+	if got, want := r.UnmatchedPatterns(), []string{"./tools/..."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmatchedPatterns = %q want %q", got, want)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"; import _ "x/y"
+		d/d.go: package d; import _ "e"; import _ "x/z"
+		x/y/y.go: package y
+	`)
+	defer clean()
+	r.Exclude = []string{"e", "x/..."}
+	r = mustResolver(t, r.Config)
+
+	pkgs := r.packages([]string{"p"})
+	deps := r.dependencies(pkgs)
+	if anyErr(append(pkgs, deps...)) {
+		t.Error("unexpected load error")
+	}
+	if got, want := names(deps), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+}
+
+func TestWriteGraphJSON(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		p/vendor/e/e.go: package e; import _ "fmt"
+		d/d.go:          package d; import _ "f"
+		e/e.go:          package e
+		f/f.go:          package f
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	deps := r.dependencies(roots)
+	var buf bytes.Buffer
+	if err := r.WriteGraphJSON(&buf, deps, r.Vendored(roots)); err != nil {
+		t.Fatal(err)
+	}
+	var got []graphPackage
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(r.Context.GOPATH, "src")
+	want := []graphPackage{
+		{ImportPath: "d", Dir: filepath.Join(src, "d"), Imports: []string{"f"}},
+		{ImportPath: "e", Dir: filepath.Join(r.Dir, "vendor", "e"), Imports: []string{"fmt"}, Vendored: true},
+		{ImportPath: "f", Dir: filepath.Join(src, "f")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("graph = %+v want %+v", got, want)
+	}
+}
+
+func TestWriteErrorsJSON(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+		d/d.go: package d; import _ "missing"
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	deps := r.dependencies(roots)
+	var buf bytes.Buffer
+	if err := WriteErrorsJSON(&buf, append(roots, deps...)); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d errors, want 1:\n%s", len(got), buf.Bytes())
+	}
+	e := got[0]
+	if e["ImportPath"] != "missing" {
+		t.Errorf("ImportPath = %v want missing", e["ImportPath"])
+	}
+	if stk, _ := e["ImportStack"].([]interface{}); len(stk) != 3 || stk[0] != "p" || stk[2] != "missing" {
+		t.Errorf("ImportStack = %v want [p d missing]", e["ImportStack"])
+	}
+	if pos, _ := e["Pos"].(string); !strings.HasPrefix(pos, filepath.Join("..", "d", "d.go")+":") {
+		t.Errorf("Pos = %v, want position in d.go", e["Pos"])
+	}
+	if msg, _ := e["Err"].(string); !strings.Contains(msg, "cannot find package") {
+		t.Errorf("Err = %v", e["Err"])
+	}
+	if e["IsImportCycle"] != false {
+		t.Errorf("IsImportCycle = %v want false", e["IsImportCycle"])
+	}
+}
+
+func TestWriteGraphDot(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"; import _ "fmt"
+		p/vendor/e/e.go: package e
+		d/d.go:          package d; import _ "f"; import _ "strings"
+		f/f.go:          package f
+	`)
+	defer clean()
+
+	var buf bytes.Buffer
+	if err := WriteGraphDot(&buf, r.packages([]string{"p"})); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph deps {
+	"d";
+	"f";
+	"p" [shape=box, style=bold];
+	"p/vendor/e";
+	"d" -> "f";
+	"p" -> "d";
+	"p" -> "p/vendor/e";
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("dot = %s want %s", got, want)
+	}
+}
+
+func TestWriteList(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"; import _ "fmt"
+		p/vendor/e/e.go: package e
+		d/d.go:          package d; import _ "f"; import _ "d/sub"
+		d/sub/s.go:      package sub
+		f/f.go:          package f
+	`)
+	defer clean()
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	var buf bytes.Buffer
+	if err := WriteList(&buf, deps, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "d\nd/sub\nf\n"; got != want {
+		t.Errorf("list = %q want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := WriteList(&buf, deps, "{{.Name}} {{.Imports}}"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "d [d/sub f]\nsub []\nf []\n"; got != want {
+		t.Errorf("list -f = %q want %q", got, want)
+	}
+
+	if err := WriteList(&buf, deps, "{{.Name"); err == nil {
+		t.Error("WriteList accepted an invalid template")
+	}
+}
+
+func TestCopyLicenses(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:        package p; import _ "r/d"; import _ "r/e"; import _ "s/f"
+		r/.git/HEAD:   0123456789abcdef0123456789abcdef01234567
+		r/LICENSE:     r license
+		r/NOTICE.txt:  r notice
+		r/d/d.go:      package d
+		r/e/e.go:      package e
+		r/e/COPYING:   e license
+		LICENSE:       not s's license
+		s/f/f.go:      package f
+	`)
+	defer clean()
+	r.Licenses = true
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"r/d/LICENSE", "r/d/NOTICE.txt", "r/e/COPYING"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"r/e/LICENSE", "s/f/LICENSE"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+	r.Update = []string{"..."}
+	r = mustResolver(t, r.Config)
+	if !r.verifyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Error("verifyDeps = false after copy")
+	}
+}
+
+func TestWarnNoLicense(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:    package p; import _ "d"; import _ "e"; import _ "f"
+		d/d.go:    package d
+		d/LICENSE: d license
+		e/e.go:    package e
+		f/f.go:    package f
+	`)
+	defer clean()
+	r.Licenses = true
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	var ok bool
+	got := capture(t, &os.Stderr, func() { ok = r.copyDeps(deps) })
+	if !ok {
+		t.Error("copyDeps failed")
+	}
+	want := "warning: no license found for e\n" +
+		"warning: no license found for f\n" +
+		"warning: 2 package(s) with no license\n"
+	if got != want {
+		t.Errorf("stderr = %q want %q", got, want)
+	}
+
+	r.Verbosity = -1 // -q
+	got = capture(t, &os.Stderr, func() { ok = r.copyDeps(deps) })
+	if !ok {
+		t.Error("copyDeps failed")
+	}
+	if got != "" {
+		t.Errorf("-q stderr = %q want none", got)
+	}
+}
+
+// capture calls f and returns what it wrote
+// to *file, which is os.Stdout or os.Stderr.
+func capture(t *testing.T, file **os.File, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	c := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		c <- b
+	}()
+	save := *file
+	*file = w
+	defer func() { *file = save }()
+	f()
+	*file = save
+	w.Close()
+	return string(<-c)
+}
+
+func TestIgnore(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:            package p; import _ "d"
+		d/d.go:            package d
+		d/data.bin:        big
+		d/sub/data.bin:    big
+		d/sub/s.go:        package sub
+		d/corpus/r.txt:    big
+		d/x/corpus/r.txt:  big
+		d/x/x.go:          package x
+	`)
+	defer clean()
+	err := ioutil.WriteFile(filepath.Join(r.Dir, IgnoreName), []byte("# big stuff\n\n*.bin\ncorpus/\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Ignores, r.PackageIgnores, err = ReadIgnore(filepath.Join(r.Dir, IgnoreName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"*.bin", "corpus/"}; !reflect.DeepEqual(r.Ignores, want) || r.PackageIgnores != nil {
+		t.Errorf("ReadIgnore = %q, %q want %q, nil", r.Ignores, r.PackageIgnores, want)
+	}
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d.go", "sub/data.bin", "sub/s.go", "x/corpus/r.txt", "x/x.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"data.bin", "corpus"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", name)); !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+}
+
+func TestReadIgnoreMissing(t *testing.T) {
+	pats, pkgPats, err := ReadIgnore(filepath.Join(os.TempDir(), "vexp-no-such-file"))
+	if pats != nil || pkgPats != nil || err != nil {
+		t.Errorf("ReadIgnore = %q, %q, %v want nil, nil, nil", pats, pkgPats, err)
+	}
+}
+
+func TestPackageIgnore(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:                 package p; import _ "d"; import _ "e"; import _ "f/g"
+		d/d.go:                 package d
+		d/fixtures/huge.json:   big
+		d/fixtures/small.json:  small
+		e/e.go:                 package e
+		e/fixtures/huge.json:   big
+		f/g/g.go:               package g
+		f/g/fixtures/huge.json: big
+	`)
+	defer clean()
+	ignore := "*.bin\n[d]\nfixtures/huge.json\n\n[f/...]\nfixtures/\n"
+	err := ioutil.WriteFile(filepath.Join(r.Dir, IgnoreName), []byte(ignore), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Ignores, r.PackageIgnores, err = ReadIgnore(filepath.Join(r.Dir, IgnoreName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"d": {"fixtures/huge.json"}, "f/...": {"fixtures/"}}
+	if !reflect.DeepEqual(r.Ignores, []string{"*.bin"}) || !reflect.DeepEqual(r.PackageIgnores, want) {
+		t.Errorf("ReadIgnore = %q, %q want [*.bin], %q", r.Ignores, r.PackageIgnores, want)
+	}
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "d/fixtures/small.json", "e/fixtures/huge.json", "f/g/g.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"d/fixtures/huge.json", "f/g/fixtures"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(r.Dir, IgnoreName), []byte("[]\n*.bin\n"), 0666)
+	if _, _, err := ReadIgnore(filepath.Join(r.Dir, IgnoreName)); err == nil {
+		t.Error("ReadIgnore with [] = nil error, want error")
+	}
+}
+
+func TestGoFilesOnly(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:         package p; import _ "d"
+		d/d.go:         package d
+		d/d.c:          int x;
+		d/d.h:          int x;
+		d/README.md:    read me
+		d/LICENSE:      license
+		d/img/logo.png: png
+		d/sub/s.go:     package sub
+	`)
+	defer clean()
+	r.GoFilesOnly = true
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d.go", "d.c", "d.h", "LICENSE", "sub/s.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"README.md", "img/logo.png"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s copied, err = %v", name, err)
+		}
+	}
+}
+
+func TestMinimal(t *testing.T) {
+	tab := `
+		p/p.go:           package p; import _ "d"; import _ "d/used"
+		d/d.go:           package d
+		d/d_test.go:      package d
+		d/d.go~:          package d
+		d/d.c:            int x;
+		d/d.h:            int x;
+		d/README.md:      read me
+		d/LICENSE:        license
+		d/img/logo.png:   png
+		d/data/x.json:    {}
+		d/unused/u.go:    package unused
+		d/used/u.go:      package used
+		d/used/notes.txt: notes
+	`
+	tests := []struct {
+		notest bool
+		want   []string
+	}{
+		{false, []string{"LICENSE", "d.c", "d.go", "d.h", "d_test.go", "used/u.go"}},
+		{true, []string{"LICENSE", "d.c", "d.go", "d.h", "used/u.go"}},
+	}
+	for _, test := range tests {
+		r, clean := setup(t, "p", tab)
+		r.Minimal = true
+		r.NoTest = test.notest
+		if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+			t.Fatal("copyDeps failed")
+		}
+		var got []string
+		root := filepath.Join(r.Dir, "vendor", "d")
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				rel, _ := filepath.Rel(root, path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("notest=%v: vendor/d = %v want %v", test.notest, got, test.want)
+		}
+		for _, dir := range []string{"img", "data", "unused"} {
+			if _, err := os.Stat(filepath.Join(root, dir)); !os.IsNotExist(err) {
+				t.Errorf("notest=%v: %s copied, err = %v", test.notest, dir, err)
+			}
+		}
+		if !r.verifyDeps(r.dependencies(r.packages([]string{"p"}))) {
+			t.Errorf("notest=%v: verifyDeps = false after copy", test.notest)
+		}
+		clean()
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:                   package p
+		p/vendor/x/x.go:          package x
+		p/vendor/a/a.go:          package a
+		p/vendor/a/vendor/x/x.go: package x
+		p/vendor/b/vendor/x/x.go: package x
+		p/vendor/b/vendor/y/y.go: package y
+		p/vendor/c/README:        no go files
+		p/vendor/c/vendor/y/y.go: package y
+		p/vendor/z/vendor/a/a.go: package a
+	`)
+	defer clean()
+	got, err := r.Duplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendor := filepath.Join(r.Dir, "vendor")
+	want := []Conflict{
+		{"a", []string{filepath.Join(vendor, "a"), filepath.Join(vendor, "z", "vendor", "a")}},
+		{"x", []string{
+			filepath.Join(vendor, "a", "vendor", "x"),
+			filepath.Join(vendor, "b", "vendor", "x"),
+			filepath.Join(vendor, "x"),
+		}},
+		{"y", []string{filepath.Join(vendor, "b", "vendor", "y"), filepath.Join(vendor, "c", "vendor", "y")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Duplicates = %v want %v", got, want)
+	}
+
+	os.RemoveAll(vendor)
+	if got, err := r.Duplicates(); err != nil || len(got) > 0 {
+		t.Errorf("Duplicates with no vendor tree = %v, %v", got, err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	tab := `
+		p/p.go:            package p; import _ "ok.com/a"; import _ "fmt"
+		q/q.go:            package q; import _ "ok.com/a"; import _ "bad.com/b"
+		ok.com/a/a.go:     package a; import _ "ok.com/a/sub"
+		ok.com/a/sub/s.go: package sub
+		bad.com/b/b.go:    package b
+	`
+	r, clean := setup(t, "p", tab)
+	defer clean()
+	r.Allow = []string{"ok.com", "other.org"}
+	r = mustResolver(t, r.Config)
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if anyErr(deps) {
+		t.Errorf("allowed dependencies have errors")
+	}
+	if got, want := names(deps), []string{"ok.com/a", "ok.com/a/sub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+
+	r, clean = setup(t, "q", tab)
+	defer clean()
+	r.Allow = []string{"ok.com"}
+	r = mustResolver(t, r.Config)
+	var errs []string
+	for _, d := range r.dependencies(r.packages([]string{"q"})) {
+		if d.Error != nil {
+			errs = append(errs, d.Error.Error())
+		}
+	}
+	want := []string{"q.go:1:40: package bad.com/b, needed by root q, is not allowed by -allow"}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors = %q want %q", errs, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		d/d.go:          package d; import _ "e"
+		d/vendor/e/e.go: package e
+		e/e.go:          package e
+	`)
+	defer clean()
+	r.Flatten = true
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "e/e.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "vendor")); !os.IsNotExist(err) {
+		t.Errorf("d/vendor copied, err = %v", err)
+	}
+}
+
+func TestNested(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		d/d.go:          package d; import _ "e"; import _ "f"
+		d/vendor/e/e.go: package e // d's version
+		e/e.go:          package e
+		f/f.go:          package f
+	`)
+	defer clean()
+	r.Nested = true
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if got, want := names(deps), []string{"d", "d/vendor/e", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "d/vendor/e/e.go", "e/e.go", "f/f.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	for _, dry := range []bool{false, true} {
+		r, clean := setup(t, "p", `
+			p/p.go:     package p; import _ "d"; import _ "e"
+			d/d.go:     package d
+			e/e.go:     package e
+			e/data.txt: 0123456789012345678901234567890123456789
+		`)
+		r.Stats = true
+		r.DryRun = dry
+
+		deps := r.dependencies(r.packages([]string{"p"}))
+		var ok bool
+		got := capture(t, &os.Stdout, func() { ok = r.copyDeps(deps) })
+		if !ok {
+			t.Error("copyDeps failed")
+		}
+		want := "" +
+			"          51 bytes      2 files  e\n" +
+			"          10 bytes      1 files  d\n" +
+			"          61 bytes      3 files  total\n"
+		if dry {
+			want = "add d\nadd e\n" + want
+		}
+		if got != want {
+			t.Errorf("-n=%v output = %q want %q", dry, got, want)
+		}
+		_, err := os.Stat(filepath.Join(r.Dir, "vendor"))
+		if dry && !os.IsNotExist(err) {
+			t.Errorf("-n created vendor, err = %v", err)
+		}
+		if !dry && err != nil {
+			t.Error(err)
+		}
+		clean()
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:      package p; import _ "d"
+		d/d.go:      package d
+		gen/g.go:    package d
+		gen/sub/s.go: package sub
+		real.go:     package d
+	`)
+	defer clean()
+	src := filepath.Join(r.Context.GOPATH, "src")
+	links := []struct{ old, new string }{
+		{"../real.go", "d/file.go"},
+		{"../gen", "d/gen"},
+		{".", "d/loop"},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.old, filepath.Join(src, filepath.FromSlash(l.new))); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	if !r.copyDeps(r.dependencies(r.packages([]string{"p"}))) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d.go", "file.go", "gen/g.go", "gen/sub/s.go"} {
+		fi, err := os.Lstat(filepath.Join(r.Dir, "vendor", "d", filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("%s is a symlink", name)
+		}
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(r.Dir, "vendor", "d", "file.go")); string(b) != "package d\n" {
+		t.Errorf("file.go = %q want %q", b, "package d\n")
+	}
+	if _, err := os.Lstat(filepath.Join(r.Dir, "vendor", "d", "loop")); !os.IsNotExist(err) {
+		t.Errorf("loop followed, err = %v", err)
+	}
+}
+
+func TestSymlinkedWorkspace(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"
+		p/vendor/d/d.go: package d; import _ "e"
+		e/e.go:          package e
+	`)
+	defer clean()
+	link := r.Context.GOPATH + "-link"
+	if err := os.Symlink(r.Context.GOPATH, link); err != nil {
+		t.Skip(err)
+	}
+	defer os.Remove(link)
+
+	// Packages in cwd are found by their real path,
+	// but their root is the symlinked GOPATH entry.
+	r.Context.GOPATH = link
+	r = mustResolver(t, r.Config)
+	pkgs := r.packages([]string{"."})
+	deps := r.dependencies(pkgs)
+	if anyErr(append(pkgs, deps...)) {
+		t.Fatal("unexpected error")
+	}
+	if got, want := names(deps), []string{"e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+}
+
+func TestVendoredImportPathOutsideRoot(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+		d/d.go: package d
+	`)
+	defer clean()
+	parent := &Package{Package: &build.Package{
+		ImportPath: "p",
+		Dir:        r.Dir,
+		Root:       filepath.Join(r.Context.GOPATH, "elsewhere"),
+	}}
+	got, _, err := r.vendoredImportPath(parent, "d")
+	if err == nil {
+		t.Error("vendoredImportPath err = nil, want error")
+	} else if !strings.Contains(err.Error(), "dir=") || !strings.Contains(err.Error(), "root=") {
+		t.Errorf("error %q lacks dir and root", err)
+	}
+	if got != "d" {
+		t.Errorf("vendoredImportPath = %q want %q", got, "d")
+	}
+
+	var stk importStack
+	p := r.loadImport("d", r.Dir, parent, &stk, nil)
+	if p.Error == nil {
+		t.Error("loadImport error = nil, want error")
+	}
+}
+
+func TestMultipleGOPATH(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"
+	`)
+	defer clean()
+	wksp2, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wksp2)
+	files := []string{
+		filepath.Join(wksp2, "src", "d", "d.go"),
+		// Not a vendor tree: it's outside $GOPATH/src.
+		filepath.Join(r.Context.GOPATH, "vendor", "d", "d.go"),
+	}
+	for _, name := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("package d\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Context.GOPATH += string(filepath.ListSeparator) + wksp2
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"."}))
+	if anyErr(deps) {
+		t.Fatal("unexpected error")
+	}
+	if got, want := names(deps), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if deps[0].Dir != filepath.Join(wksp2, "src", "d") {
+		t.Errorf("d found in %s, want second GOPATH entry", deps[0].Dir)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "d", "d.go")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadGoMod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "go.mod")
+	body := `module example.com/me // comment
+
+go 1.12
+
+require example.com/a v1.0.0
+require (
+	example.com/b v1.2.3 // indirect
+	"example.com/c" v0.1.0
+)
+
+replace example.com/b => ../b
+replace example.com/c v0.1.0 => example.com/d v0.2.0
+`
+	if err := ioutil.WriteFile(name, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readGoMod(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.path != "example.com/me" {
+		t.Errorf("path = %q want %q", m.path, "example.com/me")
+	}
+	wantReq := map[string]string{
+		"example.com/a": "v1.0.0",
+		"example.com/b": "v1.2.3",
+		"example.com/c": "v0.1.0",
+	}
+	if !reflect.DeepEqual(m.require, wantReq) {
+		t.Errorf("require = %v want %v", m.require, wantReq)
+	}
+	wantRep := map[string]modTarget{
+		"example.com/b": {"../b", ""},
+		"example.com/c": {"example.com/d", "v0.2.0"},
+	}
+	if !reflect.DeepEqual(m.replace, wantRep) {
+		t.Errorf("replace = %v want %v", m.replace, wantRep)
+	}
+	if got := findGoMod(filepath.Join(dir, "x", "y")); got != name {
+		t.Errorf("findGoMod = %q want %q", got, name)
+	}
+}
+
+func TestModCache(t *testing.T) {
+	r, clean := setup(t, "m", `
+		m/m.go:     package m; import (_ "example.com/Dep/sub"; _ "example.com/me/inner")
+		m/inner/i.go: package inner
+		gp/pkg/mod/example.com/!dep@v1.0.0/sub/sub.go: package sub
+		gp/pkg/mod/example.com/!dep@v1.0.0/LICENSE:    license
+	`)
+	defer clean()
+	// Put the module outside $GOPATH.
+	r.Context.GOPATH = filepath.Join(filepath.Dir(r.Dir), "gp")
+	gomod := "module example.com/me\n\nrequire example.com/Dep v1.0.0\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Dir, "go.mod"), []byte(gomod), 0666); err != nil {
+		t.Fatal(err)
+	}
+	r.ModCache = true
+	r = mustResolver(t, r.Config)
+	r.Licenses = true
+
+	args, err := r.rootArgs([]string{"example.com/me/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := r.packages(args)
+	deps := r.dependencies(roots)
+	if anyErr(append(roots, deps...)) {
+		for _, p := range append(roots, deps...) {
+			if p.Error != nil {
+				t.Log(p.Error)
+			}
+		}
+		t.Fatal("unexpected error")
+	}
+	if got, want := names(deps), []string{"example.com/Dep/sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	vdir := filepath.Join(r.Dir, "vendor", "example.com", "Dep", "sub")
+	for _, name := range []string{"sub.go", "LICENSE"} {
+		if _, err := os.Stat(filepath.Join(vdir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if v := r.revision(deps[0].Dir, deps[0].SrcRoot); v != "v1.0.0" {
+		t.Errorf("revision = %q want %q", v, "v1.0.0")
+	}
+
+	// Now the vendored copy should be used.
+	r = mustResolver(t, r.Config)
+	roots = r.packages(args)
+	if deps := r.dependencies(roots); anyErr(roots) || len(deps) != 0 {
+		t.Errorf("after copy, dependencies = %v want none", names(deps))
+	}
+	if got, want := names(r.Vendored(roots)), []string{"example.com/me/vendor/example.com/Dep/sub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Vendored = %v want %v", got, want)
+	}
+}
+
+func BenchmarkCopyDep(b *testing.B) {
+	wksp, err := ioutil.TempDir("", "vexp-test-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(wksp)
+	c := New(filepath.Join(wksp, "src", "p"))
+	c.Context.GOPATH = wksp
+	r := mustResolver(b, c)
+
+	dir := filepath.Join(wksp, "src", "d")
+	for i := 0; i < 1000; i++ {
+		sub := filepath.Join(dir, fmt.Sprint("sub", i%10))
+		if err := os.MkdirAll(sub, 0777); err != nil {
+			b.Fatal(err)
+		}
+		name := filepath.Join(sub, fmt.Sprintf("f%d.go", i))
+		if err := ioutil.WriteFile(name, bytes.Repeat([]byte("x"), 2000), 0666); err != nil {
+			b.Fatal(err)
+		}
+	}
+	pkg := &Package{Package: &build.Package{ImportPath: "d", Dir: dir}}
+
+	for _, bc := range []struct {
+		name string
+		buf  []byte
+	}{
+		{"PerFile", nil},
+		{"Shared", make([]byte, copyBufSize)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				os.RemoveAll(filepath.Join(r.Dir, "vendor"))
+				var st copyStats
+				if !r.copyDep(pkg, bc.buf, &st, ioutil.Discard) {
+					b.Fatal("copyDep failed")
+				}
+			}
+		})
+	}
+}
+
+func TestVerboseAddUpdate(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"; import _ "f"
+		p/vendor/e/e.go: package e // old
+		p/vendor/f/f.go: package f
+		d/d.go:          package d
+		e/e.go:          package e
+		f/f.go:          package f
+	`)
+	defer clean()
+	r.Update = []string{"e", "f"}
+	r = mustResolver(t, r.Config)
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	r.Verbosity = levelVerbose
+	var ok bool
+	got := capture(t, &os.Stdout, func() { ok = r.copyDeps(deps) })
+	if !ok {
+		t.Error("copyDeps failed")
+	}
+	if want := "add d\nupdate e\nunchanged f\n"; got != want {
+		t.Errorf("output = %q want %q", got, want)
+	}
+	if got, want := r.summary.String(), "1 added, 1 updated, 1 unchanged"; got != want {
+		t.Errorf("summary = %q want %q", got, want)
+	}
+
+	// After -clean, everything is new, even under -n,
+	// when nothing is removed, and vexp resolves as if it were.
+	r.Update = []string{"..."}
+	r = mustResolver(t, r.Config)
+	r.DryRun = true
+	r.Stdout = ioutil.Discard
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	r.Stdout = nil
+	deps = r.dependencies(r.packages([]string{"p"}))
+	got = capture(t, &os.Stdout, func() { ok = r.copyDeps(deps) })
+	if !ok {
+		t.Error("copyDeps failed")
+	}
+	if want := "add d\nadd e\nadd f\n"; got != want {
+		t.Errorf("-clean -n output = %q want %q", got, want)
+	}
+}
+
+func TestVerboseLevels(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "d"; import _ "e"
+		p/vendor/d/d.go: package d
+		e/e.go:          package e
+	`)
+	defer clean()
+	r.Verbosity = levelFiles
+
+	// Output to stdout is checked elsewhere.
+	quietly := func(f func()) func() {
+		return func() { capture(t, &os.Stdout, f) }
+	}
+	var deps []*Package
+	got := capture(t, &os.Stderr, quietly(func() { deps = r.dependencies(r.packages([]string{"p"})) }))
+	search := filepath.Join(r.Dir, "vendor", "d")
+	if !strings.Contains(got, "search "+search+" for d\n") {
+		t.Errorf("stderr = %q, want search of %s", got, search)
+	}
+	got = capture(t, &os.Stderr, quietly(func() {
+		if !r.copyDeps(deps) {
+			t.Error("copyDeps failed")
+		}
+	}))
+	want := "copy " + filepath.Join(r.Context.GOPATH, "src", "e", "e.go") + "\n"
+	if got != want {
+		t.Errorf("stderr = %q want %q", got, want)
+	}
+
+	r.Verbosity = levelVerbose
+	got = capture(t, &os.Stderr, quietly(func() {
+		if !r.copyDeps(deps) {
+			t.Error("copyDeps failed")
+		}
+	}))
+	if got != "" {
+		t.Errorf("-v stderr = %q want none", got)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/a/a.go:          package a; import _ "x"; import _ "y"
+		p/a/vendor/x/x.go: package x
+		p/b/b.go:          package b; import _ "x"; import _ "y"
+		x/x.go:            package x
+		y/y.go:            package y
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"./a", "./b"})
+	got := r.Conflicts(roots)
+	want := []Conflict{{
+		ImportPath: "x",
+		Dirs: []string{
+			filepath.Join(r.Dir, "a", "vendor", "x"),
+			filepath.Join(r.Context.GOPATH, "src", "x"),
+		},
+	}}
+	sort.Strings(want[0].Dirs)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Conflicts = %+v want %+v", got, want)
+	}
+
+	// No conflict when both roots share the top-level vendor tree.
+	r = mustResolver(t, r.Config)
+	if got := r.Conflicts(r.packages([]string{"./b"})); len(got) != 0 {
+		t.Errorf("Conflicts = %+v want none", got)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "d"; import _ "missing"
+		d/d.go: package d; import _ "e"
+		e/e.go: package e
+	`)
+	defer clean()
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if !anyErr(deps) {
+		t.Fatal("no error loading missing package")
+	}
+	deps = Loaded(deps)
+	if got, want := names(deps), []string{"d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Loaded = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"d/d.go", "e/e.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestImportChains(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p; import _ "a"; import _ "b"
+		p/p_test.go:     package p; import _ "x"
+		p/vendor/b/b.go: package b; import _ "a"; import _ "x"
+		a/a.go:          package a; import _ "x"; import _ "y"
+		x/x.go:          package x
+		y/y.go:          package y
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	if anyErr(append(roots, r.dependencies(roots)...)) {
+		t.Fatal("unexpected error")
+	}
+	got := r.ImportChains(roots, "x")
+	want := [][]string{
+		{"p", "a", "x"},
+		{"p", "p/vendor/b", "a", "x"},
+		{"p", "p/vendor/b", "x"},
+		{"p", "x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportChains(x) = %v want %v", got, want)
+	}
+	if got := r.ImportChains(roots, "b"); !reflect.DeepEqual(got, [][]string{{"p", "p/vendor/b"}}) {
+		t.Errorf("ImportChains(b) = %v", got)
+	}
+
+	var buf bytes.Buffer
+	WriteChains(&buf, [][]string{{"p", "p/vendor/b"}}, "b")
+	if want := "p\n\timports p/vendor/b\n"; buf.String() != want {
+		t.Errorf("WriteChains = %q want %q", buf.String(), want)
+	}
+	buf.Reset()
+	WriteChains(&buf, r.ImportChains(roots, "z"), "z")
+	if want := "package z is not a dependency\n"; buf.String() != want {
+		t.Errorf("WriteChains = %q want %q", buf.String(), want)
+	}
+}
+
+func TestWhyPlatforms(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:        package p; import _ "a"
+		p/p_darwin.go: package p; import _ "f"
+		a/a.go:        package a
+		a/a_darwin.go: package a; import _ "e"
+		e/e.go:        package e
+		f/f.go:        package f
+	`)
+	defer clean()
+	var err error
+	r.Platforms, err = ParsePlatforms("linux/amd64,darwin/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := r.loadRoots([]string{"p"})
+	deps := r.dependencies(roots)
+	if anyErr(append(roots, deps...)) {
+		t.Fatal("unexpected error")
+	}
+	if got, want := names(deps), []string{"a", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies = %v want %v", got, want)
+	}
+	if got, want := r.ImportChains(roots, "e"), [][]string{{"p", "a", "e"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportChains(e) = %v want %v", got, want)
+	}
+	if got, want := r.ShortestChain(roots, "e"), []string{"p", "a", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortestChain(e) = %v want %v", got, want)
+	}
+	if got, want := r.ImportChains(roots, "f"), [][]string{{"p", "f"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportChains(f) = %v want %v", got, want)
+	}
+}
+
+func TestShortestChain(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go: package p; import _ "a"; import _ "b"
+		a/a.go: package a; import _ "c"
+		b/b.go: package b; import _ "x"
+		c/r.go: package c; import _ "x"
+		x/x.go: package x
+	`)
+	defer clean()
+
+	roots := r.packages([]string{"p"})
+	if anyErr(append(roots, r.dependencies(roots)...)) {
+		t.Fatal("unexpected error")
+	}
+	if got, want := r.ShortestChain(roots, "x"), []string{"p", "b", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortestChain(x) = %v want %v", got, want)
+	}
+	if got, want := r.ShortestChain(roots, "p"), []string{"p"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortestChain(p) = %v want %v", got, want)
+	}
+	if got := r.ShortestChain(roots, "z"); got != nil {
+		t.Errorf("ShortestChain(z) = %v want nil", got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:          package p
+		p/vendor/z/z.go: package z
+		x/x.go:          package x; import _ "y"
+		y/y.go:          package y
+		z/z.go:          package z
+	`)
+	defer clean()
+
+	roots := r.packages([]string{".", "x", "z"})
+	deps := r.dependencies(roots)
+	if anyErr(append(roots, deps...)) {
+		t.Fatal("unexpected error")
+	}
+	// z is already vendored, so stays put.
+	if got, want := names(deps), []string{"x", "y"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if !r.copyDeps(deps) {
+		t.Fatal("copyDeps failed")
+	}
+	for _, name := range []string{"x/x.go", "y/y.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAddCommand(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:                 package p; import _ "gen"
+		gen/gen.go:             package gen
+		gen/cmd/gen/main.go:    package main; import _ "gen/internal/tmpl"
+		gen/internal/tmpl/t.go: package tmpl
+	`)
+	defer clean()
+	r.Add = []string{"gen/cmd/gen"}
+	r.GOBIN = filepath.Join(r.Context.GOPATH, "gobin")
+	r.Prune = true
+	r = mustResolver(t, r.Config)
+
+	roots, deps, err := r.Resolve(context.Background(), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anyErr(append(roots, deps...)) {
+		t.Fatal("unexpected error")
+	}
+	if got, want := names(deps), []string{"gen", "gen/cmd/gen", "gen/internal/tmpl"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies = %v want %v", got, want)
+	}
+	if err := r.Copy(context.Background(), deps); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"gen/cmd/gen/main.go", "gen/internal/tmpl/t.go"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCgoIncludes(t *testing.T) {
+	r, clean := setup(t, "p", `
+		p/p.go:         package p; import _ "r/sub/c"; import _ "o"
+		r/.git/HEAD:    ref: refs/heads/master
+		r/include/r.h:  int r;
+		r/sub/c/inc/i.h: int i;
+		elsewhere/e.h:  int e;
+	`)
+	defer clean()
+	// The #cgo lines must be on lines of their own.
+	cgoFiles := map[string]string{
+		"r/sub/c/r.go": "package c\n\n// #cgo CFLAGS: -I../../include -I ${SRCDIR}/inc\nimport \"C\"\n",
+		"o/o.go":       "package o\n\n// #cgo CPPFLAGS: -I../elsewhere\nimport \"C\"\n",
+	}
+	for name, body := range cgoFiles {
+		name = filepath.Join(r.Context.GOPATH, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deps := r.dependencies(r.packages([]string{"p"}))
+	if anyErr(deps) {
+		t.Fatal("unexpected error")
+	}
+	var ok bool
+	got := capture(t, &os.Stderr, func() { ok = r.copyDeps(deps) })
+	if !ok {
+		t.Error("copyDeps failed")
+	}
+	want := "warning: cgo include directory " + filepath.Join(r.Context.GOPATH, "src", "elsewhere") +
+		" of o is outside its repository; the vendored copy may not build\n"
+	if got != want {
+		t.Errorf("stderr = %q want %q", got, want)
+	}
+	for _, name := range []string{"r/include/r.h", "r/sub/c/r.go", "r/sub/c/inc/i.h"} {
+		if _, err := os.Stat(filepath.Join(r.Dir, "vendor", filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "vendor", "elsewhere")); !os.IsNotExist(err) {
+		t.Errorf("copied include dir outside repository, err = %v", err)
+	}
+
+	var buf bytes.Buffer
+	WriteCgoReport(&buf, deps)
+	if want := "cgo o\ncgo r/sub/c\n"; buf.String() != want {
+		t.Errorf("cgo report = %q want %q", buf.String(), want)
+	}
+
+	r.Update = []string{"..."}
+	r = mustResolver(t, r.Config)
+	capture(t, &os.Stderr, func() { ok = r.verifyDeps(r.dependencies(r.packages([]string{"p"}))) })
+	if !ok {
+		t.Error("verifyDeps failed after copy")
+	}
+}
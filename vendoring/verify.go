@@ -0,0 +1,114 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// verifyDeps compares the files of deps against the
+// vendor tree, as copyDep would write it.
+// It prints a line for each file that is missing from
+// the vendor tree, differs from its source, or is in the
+// vendor tree but would not have been copied there,
+// and reports whether there were none.
+func (r *Resolver) verifyDeps(deps []*Package) (ok bool) {
+	ok = true
+	r.listFiles(deps)
+	r.listEmbeds(deps)
+	r.listGoMods(deps)
+	want := make(map[string]bool)
+	var seen []string
+	for _, pkg := range r.withIncludes(deps) {
+		if isSeen(pkg, seen) {
+			continue
+		}
+		seen = append(seen, pkg.ImportPath)
+		check := func(dst, src string, fi os.FileInfo) error {
+			if fi.IsDir() {
+				return nil
+			}
+			want[dst] = true
+			same, err := r.sameCopy(dst, src)
+			switch {
+			case os.IsNotExist(err):
+				r.log(r.stderr(), levelError, event{Event: "missing", ImportPath: pkg.ImportPath, Dir: src, Dest: dst, Message: r.shortPath(dst) + ": missing"})
+				ok = false
+			case err != nil:
+				return err
+			case !same:
+				r.log(r.stderr(), levelError, event{Event: "differs", ImportPath: pkg.ImportPath, Dir: src, Dest: dst, Message: r.shortPath(dst) + ": differs from " + src})
+				ok = false
+			}
+			return nil
+		}
+		if !r.walkDep(pkg, r.vendorDir(pkg), r.stderr(), check) {
+			ok = false
+		}
+		for _, src := range r.outsideLicenses(pkg) {
+			fi, err := os.Stat(src)
+			if err == nil {
+				err = check(filepath.Join(r.vendorDir(pkg), filepath.Base(src)), src, fi)
+			}
+			if !r.report(r.stderr(), pkg.ImportPath, err) {
+				ok = false
+			}
+		}
+	}
+
+	root := filepath.Join(r.cwd, r.outDir)
+	manifest := filepath.Join(root, ManifestName)
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				r.logError(r.stderr(), "", err)
+				ok = false
+			}
+			return nil
+		}
+		// Skip what copyDep would never have written.
+		_, elem := filepath.Split(path)
+		if path != root && r.skipped(elem) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() && !want[path] && path != manifest {
+			r.log(r.stderr(), levelError, event{Event: "extra", Dest: path, Message: r.shortPath(path) + ": extra file"})
+			ok = false
+		}
+		return nil
+	})
+	return ok
+}
+
+// sameContents reports whether files a and b
+// have the same contents.
+func sameContents(a, b string) (bool, error) {
+	afi, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bfi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if afi.Size() != bfi.Size() {
+		return false, nil
+	}
+	ab, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	bb, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ab, bb), nil
+}
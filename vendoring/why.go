@@ -0,0 +1,144 @@
+// Copyright 2015 Keith Rarick.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vendoring
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// isPackage reports whether p is the package named by path,
+// either as written or as seen from outside the vendor tree.
+func (r *Resolver) isPackage(p *Package, path string) bool {
+	up, _ := r.unvendoredPath(p.ImportPath)
+	return p.ImportPath == path || up == path
+}
+
+// ImportChains returns every import chain, as a list of
+// import paths, that leads from one of roots to the package
+// named by path. Each chain visits a package at most once.
+// The chains are sorted.
+func (r *Resolver) ImportChains(roots []*Package, path string) [][]string {
+	// Find the packages that lead to path,
+	// by walking the import graph backward from it.
+	importers := make(map[*Package][]*Package)
+	var todo []*Package
+	seen := make(map[*Package]bool)
+	for _, root := range roots {
+		for _, p := range append([]*Package{root}, root.deps...) {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			for _, q := range p.imports {
+				importers[q] = append(importers[q], p)
+			}
+			if r.isPackage(p, path) {
+				todo = append(todo, p)
+			}
+		}
+	}
+	leads := make(map[*Package]bool)
+	for len(todo) > 0 {
+		p := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if leads[p] {
+			continue
+		}
+		leads[p] = true
+		todo = append(todo, importers[p]...)
+	}
+
+	var chains [][]string
+	var stk []*Package
+	onStack := make(map[*Package]bool)
+	var walk func(p *Package)
+	walk = func(p *Package) {
+		if onStack[p] || !leads[p] {
+			return
+		}
+		stk = append(stk, p)
+		onStack[p] = true
+		if r.isPackage(p, path) {
+			chain := make([]string, len(stk))
+			for i, q := range stk {
+				chain[i] = q.ImportPath
+			}
+			chains = append(chains, chain)
+		} else {
+			for _, q := range p.imports {
+				walk(q)
+			}
+		}
+		onStack[p] = false
+		stk = stk[:len(stk)-1]
+	}
+	for _, p := range roots {
+		walk(p)
+	}
+	sort.Sort(byChain(chains))
+	return chains
+}
+
+// ShortestChain returns the shortest import chain that leads
+// from one of roots to the package named by path, or nil if
+// there is none. Among chains of equal length, it prefers
+// those through earlier roots and imports.
+func (r *Resolver) ShortestChain(roots []*Package, path string) []string {
+	from := make(map[*Package]*Package) // importer on the shortest chain
+	seen := make(map[*Package]bool)
+	queue := append([]*Package{}, roots...)
+	for _, p := range roots {
+		seen[p] = true
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if r.isPackage(p, path) {
+			var chain []string
+			for ; p != nil; p = from[p] {
+				chain = append([]string{p.ImportPath}, chain...)
+			}
+			return chain
+		}
+		for _, q := range p.imports {
+			if !seen[q] {
+				seen[q] = true
+				from[q] = p
+				queue = append(queue, q)
+			}
+		}
+	}
+	return nil
+}
+
+type byChain [][]string
+
+func (a byChain) Len() int           { return len(a) }
+func (a byChain) Less(i, j int) bool { return strings.Join(a[i], "\n") < strings.Join(a[j], "\n") }
+func (a byChain) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// WriteChains writes chains to w, one import per line,
+// with a blank line between chains.
+// If there are none, it says that path is not a dependency.
+func WriteChains(w io.Writer, chains [][]string, path string) error {
+	bw := bufio.NewWriter(w)
+	if len(chains) == 0 {
+		fmt.Fprintf(bw, "package %s is not a dependency\n", path)
+	}
+	for i, chain := range chains {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintln(bw, chain[0])
+		for _, p := range chain[1:] {
+			fmt.Fprintf(bw, "\timports %s\n", p)
+		}
+	}
+	return bw.Flush()
+}